@@ -0,0 +1,983 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/logging"
+	"github.com/hb9tf/wireslacker/processor"
+	"github.com/hb9tf/wireslacker/reader"
+)
+
+const (
+	backendSlack    = "slack"
+	backendDiscord  = "discord"
+	backendTelegram = "telegram"
+	backendMQTT     = "mqtt"
+)
+
+// Exit codes of wireslacker, distinct per kind of failure so scripted deployments can tell them
+// apart.
+const (
+	exitFailure   = 1 // a failure at runtime, e.g. a target which could not be read with -once
+	exitConfig    = 2 // an invalid configuration, as for invalid flags
+	exitNoWebhook = 3 // no (valid) webhook or other backend to post to
+	exitNoTargets = 4 // no target to read
+	exitLocation  = 5 // an unknown location of a target
+)
+
+// Intervals below which a warning is logged as they put unnecessary load on the node PCs and the
+// Yaesu server (the lists are only updated every 20 minutes).
+const (
+	recommendedReadInterval  = 5 * time.Second
+	recommendedYaesuInterval = 5 * time.Minute
+)
+
+// configError is an invalid configuration which is exited with a specific code.
+type configError struct {
+	code int
+	err  error
+}
+
+func (e *configError) Error() string {
+	return e.err.Error()
+}
+
+// exitCode returns the code to exit with because of the configuration error err.
+func exitCode(err error) int {
+	if ce, ok := err.(*configError); ok {
+		return ce.code
+	}
+	return exitConfig
+}
+
+var (
+	// colorRE matches the attachment colors accepted by Slack: a name or a hex color.
+	colorRE = regexp.MustCompile("^(good|warning|danger|#[0-9a-fA-F]{6})?$")
+	// slackEmojiRE matches an emoji shortcode accepted by Slack as icon (e.g. :radio:).
+	slackEmojiRE = regexp.MustCompile("^:[a-z0-9_+'-]+:$")
+	// headerNameRE matches a valid HTTP header name.
+	headerNameRE = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+	// defaultWebhookHosts are the hosts accepted for the webhook of each backend.
+	defaultWebhookHosts = map[string][]string{
+		backendSlack:   {"hooks.slack.com"},
+		backendDiscord: {"discord.com", "discordapp.com", "ptb.discord.com", "canary.discord.com"},
+	}
+)
+
+// validateWebhook ensures the webhook is a valid https URL pointing to one of the allowed hosts.
+func validateWebhook(webhook string, hosts []string) error {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return fmt.Errorf("unable to parse webhook URL: %v", err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("webhook URL must use https, got %q", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range hosts {
+		if host == strings.ToLower(h) {
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook host %q is not one of %s", host, strings.Join(hosts, ", "))
+}
+
+// detectBackend guesses the backend from the webhook URL.
+func detectBackend(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return backendSlack
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, h := range defaultWebhookHosts[backendDiscord] {
+		if host == h {
+			return backendDiscord
+		}
+	}
+	return backendSlack
+}
+
+// Duration is a time.Duration which is represented as a string (e.g. "10s") in the config file.
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string as understood by time.ParseDuration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// stringList is a flag which can be provided multiple times, collecting all values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// Config holds the full configuration of wireslacker, either read from the config file
+// or provided via flags.
+type Config struct {
+	// Targets are all the logs to poll.
+	Targets []*TargetConfig `json:"targets"`
+	// Routes post the events of some targets or logs to their own webhook or channel.
+	Routes []*RouteConfig `json:"routes"`
+	// Webhook is the webhook to use to post to slack.
+	Webhook string `json:"webhook"`
+	// WebhookHeaders are additional headers sent with each post to the webhook (e.g. a shared
+	// secret for a custom receiver), keyed by their name.
+	WebhookHeaders map[string]string `json:"webhookHeaders"`
+	// WebhookSigningKey is the key the body of each post to the webhook is signed with (see
+	// processor.WebhookSignatureHeader), no signature is sent if empty.
+	WebhookSigningKey string `json:"webhookSigningKey"`
+	// SlackToken and SlackChannel are used to post to the channel using the Slack Web API
+	// instead of the webhook. This is required for threading.
+	SlackToken   string `json:"slackToken"`
+	SlackChannel string `json:"slackChannel"`
+	// SlackUsername is the name slack messages are posted with, the default of the webhook or
+	// bot if empty.
+	SlackUsername string `json:"slackUsername"`
+	// SlackIcon is the emoji (e.g. :radio:) or the URL of the image slack messages are posted
+	// with, the default of the webhook or bot if empty.
+	SlackIcon string `json:"slackIcon"`
+	// SlackRate is the maximum average number of slack posts per second, unlimited if 0.
+	SlackRate float64 `json:"slackRate"`
+	// SlackBurst is the maximum number of slack posts sent in a burst.
+	SlackBurst int `json:"slackBurst"`
+	// PostWorkers is the number of nodes or rooms whose events are posted concurrently.
+	PostWorkers int `json:"postWorkers"`
+	// TelegramToken and TelegramChat are used to post to a Telegram chat using a bot.
+	TelegramToken string `json:"telegramToken"`
+	TelegramChat  string `json:"telegramChat"`
+	// MQTTBroker is the broker to publish to (e.g. tcp://host:1883 or ssl://host:8883).
+	MQTTBroker string `json:"mqttBroker"`
+	// MQTTTopic is the prefix of the topics, messages are published to <prefix>/<log ID>/event.
+	MQTTTopic string `json:"mqttTopic"`
+	// MQTTUser and MQTTPassword are the optional credentials to connect to the broker.
+	MQTTUser     string `json:"mqttUser"`
+	MQTTPassword string `json:"mqttPassword"`
+	// WebhookHosts are the hosts accepted for the webhook, defaulting to the ones of the backend.
+	WebhookHosts []string `json:"webhookHosts"`
+	// Backend is the coma separated list of backends to post to (slack, discord, telegram or mqtt).
+	// If empty, all backends which have been configured are used.
+	Backend string `json:"backend"`
+	// Backends is the parsed list of backends.
+	Backends []string `json:"-"`
+	// Location is the location of the Wires-X server.
+	Location string `json:"location"`
+	// TimeFormat is the default format of the event timestamps, known formats are tried if empty.
+	TimeFormat string `json:"timeFormat"`
+	// ReadInterval is the default interval in which to read the targets.
+	ReadInterval Duration `json:"readInterval"`
+	// ReadTimeout is the default time to wait for an HTTP/S target to respond.
+	ReadTimeout Duration `json:"readTimeout"`
+	// Jitter is the fraction by which the intervals of the polls and the updates of the Yaesu
+	// lists are randomly varied (e.g. 0.1 for ±10%), disabled if 0.
+	Jitter float64 `json:"jitter"`
+	// TLSCA is the path to a PEM file of additional CAs to trust for HTTPS targets and the Yaesu lists.
+	TLSCA string `json:"tlsCA"`
+	// TLSInsecure disables the verification of TLS certificates if true. Do not use in production.
+	TLSInsecure bool `json:"tlsInsecure"`
+	// Proxy is the URL of the proxy for all outbound HTTP requests, the one configured in the
+	// environment if empty.
+	Proxy string `json:"proxy"`
+	// Headers are additional headers sent to the HTTP/S targets and the Yaesu lists, keyed by
+	// their name.
+	Headers map[string]string `json:"headers"`
+	// YaesuInterval is the interval in which to refresh the Yaesu active nodes and rooms lists.
+	YaesuInterval Duration `json:"yaesuInterval"`
+	// YaesuMaxInterval is the interval up to which the refresh of the Yaesu lists is slowed down
+	// while there is no activity, disabled if 0.
+	YaesuMaxInterval Duration `json:"yaesuMaxInterval"`
+	// MinReadInterval is the shortest read interval accepted for the targets.
+	MinReadInterval Duration `json:"minReadInterval"`
+	// MinYaesuInterval is the shortest interval accepted to refresh the Yaesu lists.
+	MinYaesuInterval Duration `json:"minYaesuInterval"`
+	// YaesuNodesURL and YaesuRoomsURL are the URLs of the Yaesu active nodes and rooms lists.
+	YaesuNodesURL string `json:"yaesuNodesURL"`
+	YaesuRoomsURL string `json:"yaesuRoomsURL"`
+	// YaesuCache is the path to a file to cache the Yaesu active nodes and rooms lists.
+	YaesuCache string `json:"yaesuCache"`
+	// YaesuTimeout is the time to wait for the Yaesu active nodes and rooms lists to respond.
+	YaesuTimeout Duration `json:"yaesuTimeout"`
+	// YaesuStaleAfter is the age of the Yaesu lists after which the enriched information is
+	// marked as possibly stale, disabled if 0.
+	YaesuStaleAfter Duration `json:"yaesuStaleAfter"`
+	// Verbose logs more detailed messages if true.
+	Verbose bool `json:"verbose"`
+	// LogFormat is the format of the log messages (text or json).
+	LogFormat string `json:"logFormat"`
+	// Dry does not post to the slack channel if true.
+	Dry bool `json:"dry"`
+	// Since is how far back to post the events of a target on the first run, 0 for none.
+	Since Duration `json:"since"`
+	// Once reads each target once, posts all its events and exits if true.
+	Once bool `json:"once"`
+	// BatchWindow is the window in which events of the same log are posted as a single message.
+	BatchWindow Duration `json:"batchWindow"`
+	// DedupWindow is the window in which identical events (e.g. from different targets) are posted once.
+	DedupWindow Duration `json:"dedupWindow"`
+	// AlertAfter is how long a target has to be unreachable before an alert is posted, 0 for never.
+	AlertAfter Duration `json:"alertAfter"`
+	// Filters are additional strings: events containing any of them are not posted.
+	Filters []string `json:"filters"`
+	// FilterRegexps are regexps: events matching any of them are not posted.
+	FilterRegexps []string `json:"filterRegexps"`
+	// NoDefaultFilters disables the built-in filters if true.
+	NoDefaultFilters bool `json:"noDefaultFilters"`
+	// IncludeCategories restricts the posted events to these categories if not empty.
+	IncludeCategories []string `json:"includeCategories"`
+	// ExcludeCategories are the categories of events which are not posted.
+	ExcludeCategories []string `json:"excludeCategories"`
+	// AllowNodes restricts the posted events to the ones of these nodes (callsign, ID or DTMF ID).
+	AllowNodes []string `json:"allowNodes"`
+	// AllowLocations restricts the posted events to the ones of nodes in these countries or
+	// country/state pairs.
+	AllowLocations []string `json:"allowLocations"`
+	// AllowUnresolved posts events whose node cannot be resolved even if AllowNodes or
+	// AllowLocations is set.
+	AllowUnresolved bool `json:"allowUnresolved"`
+	// Colors maps event categories to the color of their attachment, overriding the default.
+	Colors map[string]string `json:"colors"`
+	// MapThumbURL is the URL template of a static map shown as thumbnail of enriched messages.
+	MapThumbURL string `json:"mapThumbURL"`
+	// IncludeRaw appends the raw log line to the posted events if true.
+	IncludeRaw bool `json:"includeRaw"`
+	// NodeURL is the URL template of the page of a node linked from enriched messages.
+	NodeURL string `json:"nodeURL"`
+	// MaxTextLength is the maximum length of the text of enriched messages, unlimited if 0.
+	MaxTextLength int `json:"maxTextLength"`
+	// ConnectionEvents posts an event whenever a node connects to another node or room.
+	ConnectionEvents bool `json:"connectionEvents"`
+	// Thread posts all events of the same node or room in a thread (requires a slack bot token).
+	Thread bool `json:"thread"`
+	// StatePath is the path to a file to persist the last posted event per target.
+	StatePath string `json:"state"`
+	// MetricsAddr is the address to serve Prometheus metrics on, disabled if empty.
+	MetricsAddr string `json:"metrics"`
+	// HealthAddr is the address to serve the health checks on, disabled if empty.
+	HealthAddr string `json:"healthAddr"`
+	// RecentEvents is the number of recent events served on /events, disabled if 0.
+	RecentEvents int `json:"recentEvents"`
+	// Export is the format to export each parsed log in (jsonl), disabled if empty.
+	Export string `json:"export"`
+	// ExportPath is the file to export the logs to, "-" for stdout.
+	ExportPath string `json:"exportPath"`
+	// Archive is the file to append each new event to as JSON Lines, disabled if empty.
+	Archive string `json:"archive"`
+	// ArchiveMaxSize is the size in MB above which the archive is rotated, disabled if 0.
+	ArchiveMaxSize int `json:"archiveMaxSize"`
+	// ArchiveDaily rotates the archive each day if true.
+	ArchiveDaily bool `json:"archiveDaily"`
+
+	// keys are the (lower case) keys set in the config file.
+	keys map[string]bool
+}
+
+// inFile returns true if the key is set in the config file, even if to the zero value. Keys are
+// matched case-insensitively like when decoding the file.
+func (c *Config) inFile(key string) bool {
+	return c.keys[strings.ToLower(key)]
+}
+
+// TargetConfig holds the configuration of a single target. All optional fields default
+// to the global values if empty.
+type TargetConfig struct {
+	// Target is the path or URL of the log.
+	Target string `json:"target"`
+	// Interval is the interval in which to read the log.
+	Interval Duration `json:"interval"`
+	// Timeout is the time to wait for an HTTP/S target to respond.
+	Timeout Duration `json:"timeout"`
+	// Username and Password are used for HTTP basic auth.
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Location is the location of the Wires-X server serving the log.
+	Location string `json:"location"`
+	// TimeFormat is the format of the event timestamps (see https://golang.org/pkg/time/#Parse).
+	TimeFormat string `json:"timeFormat"`
+	// TLSCA is the path to a PEM file of additional CAs to trust (HTTPS only).
+	TLSCA string `json:"tlsCA"`
+	// TLSInsecure disables the verification of the TLS certificate if true (HTTPS only).
+	TLSInsecure bool `json:"tlsInsecure"`
+	// Channel is the slack channel to post the events of this target to instead of the one of
+	// the webhook (which has to allow overriding it) or bot.
+	Channel string `json:"channel"`
+}
+
+// RouteConfig posts the events of a target or log to its own webhook or channel instead of the
+// global ones. It can only be set in the config file.
+type RouteConfig struct {
+	// Match is the target or the ID of the log (e.g. "HB9TF-ND, HB9TF(12345)") to route.
+	Match string `json:"match"`
+	// Webhook is the slack or discord webhook to post to.
+	Webhook string `json:"webhook"`
+	// SlackChannel is the channel to post to using the global slack bot token.
+	SlackChannel string `json:"slackChannel"`
+	// TelegramChat is the chat to post to using the global telegram bot token.
+	TelegramChat string `json:"telegramChat"`
+	// Backends is the parsed list of backends.
+	Backends []string `json:"-"`
+}
+
+// loadConfig reads the JSON config file from path. Unknown keys are rejected.
+func loadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("unable to parse config file %q: %v", path, err)
+	}
+	// Remember which keys are set, as some values are valid if 0.
+	keys := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("unable to parse config file %q: %v", path, err)
+	}
+	cfg.keys = map[string]bool{}
+	for k := range keys {
+		cfg.keys[strings.ToLower(k)] = true
+	}
+	for i, t := range cfg.Targets {
+		if t == nil || t.Target == "" {
+			return nil, fmt.Errorf("target #%d in config file %q has no target", i+1, path)
+		}
+	}
+	return cfg, nil
+}
+
+// newTLSConfig returns the TLS configuration trusting the CAs in the PEM file at caPath in addition
+// to the system ones and skipping the verification of certificates if insecure is true. It returns
+// nil (the default configuration) if neither is set.
+func newTLSConfig(caPath string, insecure bool) (*tls.Config, error) {
+	if caPath == "" && !insecure {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: insecure}
+	if caPath != "" {
+		pem, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %q", caPath)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// newProxy parses the URL of the proxy. It returns nil (the proxy configured in the environment)
+// if proxy is empty.
+func newProxy(proxy string) (*url.URL, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", reader.Redact(proxy), err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q, use a URL like http://host:3128", reader.Redact(proxy))
+	}
+	return u, nil
+}
+
+// splitList splits a coma separated list, dropping empty entries.
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// categoryList returns all known event categories as a coma separated list.
+func categoryList() string {
+	var l []string
+	for _, c := range data.Categories {
+		l = append(l, string(c))
+	}
+	return strings.Join(l, ", ")
+}
+
+// parseColors parses a coma separated list of category:color pairs.
+func parseColors(s string) (map[string]string, error) {
+	colors := map[string]string{}
+	for _, v := range splitList(s) {
+		kv := strings.SplitN(v, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid color %q, use category:color", v)
+		}
+		colors[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return colors, nil
+}
+
+// parseHeaders parses headers provided as "Name: value".
+func parseHeaders(list []string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, h := range list {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q, use \"Name: value\"", h)
+		}
+		headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// validCategory returns true if c is a known event category.
+func validCategory(c string) bool {
+	for _, v := range data.Categories {
+		if c == string(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRoute ensures the route is complete and sets its backends.
+func validateRoute(cfg *Config, r *RouteConfig) error {
+	if r.Match == "" {
+		return fmt.Errorf("provide the target or log ID to match")
+	}
+	switch {
+	case r.Webhook != "" && r.SlackChannel != "":
+		return fmt.Errorf("provide either a webhook or a slack channel")
+	case r.Webhook != "":
+		b := detectBackend(r.Webhook)
+		hosts := cfg.WebhookHosts
+		if len(hosts) == 0 {
+			hosts = defaultWebhookHosts[b]
+		}
+		if err := validateWebhook(r.Webhook, hosts); err != nil {
+			return fmt.Errorf("invalid webhook: %v", err)
+		}
+		r.Backends = append(r.Backends, b)
+	case r.SlackChannel != "":
+		if cfg.SlackToken == "" {
+			return fmt.Errorf("a slack channel requires the slack bot token")
+		}
+		r.Backends = append(r.Backends, backendSlack)
+	}
+	if r.TelegramChat != "" {
+		if cfg.TelegramToken == "" {
+			return fmt.Errorf("a telegram chat requires the telegram bot token")
+		}
+		r.Backends = append(r.Backends, backendTelegram)
+	}
+	if len(r.Backends) == 0 {
+		return fmt.Errorf("provide a webhook, slack channel or telegram chat")
+	}
+	return nil
+}
+
+// parseTarget parses a target provided in the -targets flag. A target can optionally be suffixed
+// by its own read interval, separated by a colon (e.g. "http://IP:port/nodelog.html:5s").
+func parseTarget(s string) *TargetConfig {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return &TargetConfig{Target: s}
+	}
+	d, err := time.ParseDuration(s[i+1:])
+	if err != nil || d <= 0 {
+		// Not an interval but part of the target itself (e.g. the port).
+		return &TargetConfig{Target: s}
+	}
+	return &TargetConfig{
+		Target:   s[:i],
+		Interval: Duration(d),
+	}
+}
+
+// getConfig merges the config file (if any) with the flags. Flags which have explicitly
+// been set on the command line override the values of the config file while config file
+// values override the flag defaults.
+func getConfig() (*Config, error) {
+	cfg := &Config{}
+	if *configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	if set["targets"] || len(cfg.Targets) == 0 {
+		cfg.Targets = nil
+		for _, t := range strings.Split(*targets, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				cfg.Targets = append(cfg.Targets, parseTarget(t))
+			}
+		}
+	}
+	if set["webhook"] || cfg.Webhook == "" {
+		cfg.Webhook = *webHook
+	}
+	if set["slackToken"] || cfg.SlackToken == "" {
+		cfg.SlackToken = *slackToken
+	}
+	if set["slackChannel"] || cfg.SlackChannel == "" {
+		cfg.SlackChannel = *slackChannel
+	}
+	if set["slackUsername"] || cfg.SlackUsername == "" {
+		cfg.SlackUsername = *slackUsername
+	}
+	if set["slackIcon"] || cfg.SlackIcon == "" {
+		cfg.SlackIcon = *slackIcon
+	}
+	if set["slackRate"] || !cfg.inFile("slackRate") {
+		cfg.SlackRate = *slackRate
+	}
+	if set["slackBurst"] || cfg.SlackBurst == 0 {
+		cfg.SlackBurst = *slackBurst
+	}
+	if set["postWorkers"] || cfg.PostWorkers == 0 {
+		cfg.PostWorkers = *postWorkers
+	}
+	if set["telegramToken"] || cfg.TelegramToken == "" {
+		cfg.TelegramToken = *telegramToken
+	}
+	if set["telegramChat"] || cfg.TelegramChat == "" {
+		cfg.TelegramChat = *telegramChat
+	}
+	if set["mqttBroker"] || cfg.MQTTBroker == "" {
+		cfg.MQTTBroker = *mqttBroker
+	}
+	if set["mqttTopic"] || cfg.MQTTTopic == "" {
+		cfg.MQTTTopic = *mqttTopic
+	}
+	if set["mqttUser"] || cfg.MQTTUser == "" {
+		cfg.MQTTUser = *mqttUser
+	}
+	if set["mqttPassword"] || cfg.MQTTPassword == "" {
+		cfg.MQTTPassword = *mqttPassword
+	}
+	if set["webhookHeader"] {
+		h, err := parseHeaders(webhookHeaders)
+		if err != nil {
+			return nil, err
+		}
+		cfg.WebhookHeaders = h
+	}
+	if set["webhookSigningKey"] || cfg.WebhookSigningKey == "" {
+		cfg.WebhookSigningKey = *webhookSigningKey
+	}
+	if set["webhookHosts"] || len(cfg.WebhookHosts) == 0 {
+		cfg.WebhookHosts = splitList(*webhookHosts)
+	}
+	if set["backend"] || cfg.Backend == "" {
+		cfg.Backend = *backend
+	}
+	if set["location"] || cfg.Location == "" {
+		cfg.Location = *location
+	}
+	if set["timeFormat"] || cfg.TimeFormat == "" {
+		cfg.TimeFormat = *timeFormat
+	}
+	if set["tlsCA"] || cfg.TLSCA == "" {
+		cfg.TLSCA = *tlsCA
+	}
+	if set["tlsInsecure"] {
+		cfg.TLSInsecure = *tlsInsecure
+	}
+	if set["proxy"] || cfg.Proxy == "" {
+		cfg.Proxy = *proxy
+	}
+	if set["header"] {
+		h, err := parseHeaders(headers)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Headers = h
+	}
+	if set["readInterval"] || cfg.ReadInterval == 0 {
+		cfg.ReadInterval = Duration(*readInterval)
+	}
+	if set["readTimeout"] || cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = Duration(*readTimeout)
+	}
+	if set["jitter"] || !cfg.inFile("jitter") {
+		cfg.Jitter = *jitter
+	}
+	if set["yaesuInterval"] || cfg.YaesuInterval == 0 {
+		cfg.YaesuInterval = Duration(*yaesuInterval)
+	}
+	if set["yaesuMaxInterval"] || !cfg.inFile("yaesuMaxInterval") {
+		cfg.YaesuMaxInterval = Duration(*yaesuMaxInterval)
+	}
+	if set["minReadInterval"] || !cfg.inFile("minReadInterval") {
+		cfg.MinReadInterval = Duration(*minReadInterval)
+	}
+	if set["minYaesuInterval"] || !cfg.inFile("minYaesuInterval") {
+		cfg.MinYaesuInterval = Duration(*minYaesuInterval)
+	}
+	if set["yaesuNodesURL"] || cfg.YaesuNodesURL == "" {
+		cfg.YaesuNodesURL = *yaesuNodesURL
+	}
+	if set["yaesuRoomsURL"] || cfg.YaesuRoomsURL == "" {
+		cfg.YaesuRoomsURL = *yaesuRoomsURL
+	}
+	if set["yaesuCache"] || cfg.YaesuCache == "" {
+		cfg.YaesuCache = *yaesuCache
+	}
+	if set["yaesuTimeout"] || cfg.YaesuTimeout == 0 {
+		cfg.YaesuTimeout = Duration(*yaesuTimeout)
+	}
+	if set["yaesuStaleAfter"] || !cfg.inFile("yaesuStaleAfter") {
+		cfg.YaesuStaleAfter = Duration(*yaesuStaleAfter)
+	}
+	if set["batchWindow"] || !cfg.inFile("batchWindow") {
+		cfg.BatchWindow = Duration(*batchWindow)
+	}
+	if set["dedupWindow"] || !cfg.inFile("dedupWindow") {
+		cfg.DedupWindow = Duration(*dedupWindow)
+	}
+	if set["alertAfter"] || !cfg.inFile("alertAfter") {
+		cfg.AlertAfter = Duration(*alertAfter)
+	}
+	if set["filter"] {
+		cfg.Filters = filters
+	}
+	if set["filterRegexp"] {
+		cfg.FilterRegexps = filterRegexps
+	}
+	if set["noDefaultFilters"] {
+		cfg.NoDefaultFilters = *noDefaultFilters
+	}
+	if set["include"] || len(cfg.IncludeCategories) == 0 {
+		cfg.IncludeCategories = splitList(*includeCategories)
+	}
+	if set["exclude"] || len(cfg.ExcludeCategories) == 0 {
+		cfg.ExcludeCategories = splitList(*excludeCategories)
+	}
+	if set["allowNodes"] || len(cfg.AllowNodes) == 0 {
+		cfg.AllowNodes = splitList(*allowNodes)
+	}
+	if set["allowLocations"] || len(cfg.AllowLocations) == 0 {
+		cfg.AllowLocations = splitList(*allowLocations)
+	}
+	if set["allowUnresolved"] {
+		cfg.AllowUnresolved = *allowUnresolved
+	}
+	if set["colors"] || len(cfg.Colors) == 0 {
+		c, err := parseColors(*colors)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Colors = c
+	}
+	if set["mapThumbURL"] || cfg.MapThumbURL == "" {
+		cfg.MapThumbURL = *mapThumbURL
+	}
+	if set["includeRaw"] {
+		cfg.IncludeRaw = *includeRaw
+	}
+	if set["nodeURL"] || cfg.NodeURL == "" {
+		cfg.NodeURL = *nodeURL
+	}
+	if set["maxTextLength"] || !cfg.inFile("maxTextLength") {
+		cfg.MaxTextLength = *maxTextLength
+	}
+	if set["connectionEvents"] {
+		cfg.ConnectionEvents = *connectionEvents
+	}
+	if set["since"] || !cfg.inFile("since") {
+		cfg.Since = Duration(*since)
+	}
+	if set["once"] {
+		cfg.Once = *once
+	}
+	if set["thread"] {
+		cfg.Thread = *thread
+	}
+	if set["state"] || cfg.StatePath == "" {
+		cfg.StatePath = *statePath
+	}
+	if set["metrics"] || cfg.MetricsAddr == "" {
+		cfg.MetricsAddr = *metricsAddr
+	}
+	if set["healthAddr"] || cfg.HealthAddr == "" {
+		cfg.HealthAddr = *healthAddr
+	}
+	if set["recentEvents"] || !cfg.inFile("recentEvents") {
+		cfg.RecentEvents = *recentEvents
+	}
+	if set["export"] || cfg.Export == "" {
+		cfg.Export = *export
+	}
+	if set["exportPath"] || cfg.ExportPath == "" {
+		cfg.ExportPath = *exportPath
+	}
+	if set["archive"] || cfg.Archive == "" {
+		cfg.Archive = *archive
+	}
+	if set["archiveMaxSize"] || !cfg.inFile("archiveMaxSize") {
+		cfg.ArchiveMaxSize = *archiveMaxSize
+	}
+	if set["archiveDaily"] {
+		cfg.ArchiveDaily = *archiveDaily
+	}
+	if set["logformat"] || cfg.LogFormat == "" {
+		cfg.LogFormat = *logFormat
+	}
+	if set["v"] {
+		cfg.Verbose = *verbose
+	}
+	if set["dry"] {
+		cfg.Dry = *dry
+	}
+
+	// Fill in the per-target defaults.
+	for _, t := range cfg.Targets {
+		if t.Interval == 0 {
+			t.Interval = cfg.ReadInterval
+		}
+		if t.Timeout == 0 {
+			t.Timeout = cfg.ReadTimeout
+		}
+		if set["httpUser"] || t.Username == "" {
+			t.Username = *httpUser
+		}
+		if set["httpPassword"] || t.Password == "" {
+			t.Password = *httpPassword
+		}
+		if t.Location == "" {
+			t.Location = cfg.Location
+		}
+		if t.TimeFormat == "" {
+			t.TimeFormat = cfg.TimeFormat
+		}
+		if t.TLSCA == "" {
+			t.TLSCA = cfg.TLSCA
+		}
+		if cfg.TLSInsecure {
+			t.TLSInsecure = true
+		}
+	}
+
+	// Ensure necessary settings have been provided.
+	cfg.Backends = splitList(cfg.Backend)
+	if len(cfg.Backends) == 0 {
+		// Post to all backends which have been configured.
+		if cfg.Webhook != "" || cfg.SlackToken != "" {
+			cfg.Backends = append(cfg.Backends, detectBackend(cfg.Webhook))
+		}
+		if cfg.TelegramToken != "" {
+			cfg.Backends = append(cfg.Backends, backendTelegram)
+		}
+		if cfg.MQTTBroker != "" {
+			cfg.Backends = append(cfg.Backends, backendMQTT)
+		}
+	}
+	switch cfg.Export {
+	case "", processor.ExportJSONL:
+	default:
+		return nil, fmt.Errorf("unknown export format %q, use %q", cfg.Export, processor.ExportJSONL)
+	}
+	if cfg.ArchiveMaxSize < 0 {
+		return nil, fmt.Errorf("maximum archive size must not be negative")
+	}
+	if len(cfg.Backends) == 0 && cfg.Export == "" && cfg.Archive == "" {
+		return nil, &configError{exitNoWebhook, fmt.Errorf("provide a valid webhook URL for slack")}
+	}
+	if len(cfg.Targets) == 0 {
+		return nil, &configError{exitNoTargets, fmt.Errorf("provide at least one target")}
+	}
+	if cfg.YaesuInterval <= 0 {
+		return nil, fmt.Errorf("yaesu update interval must be positive")
+	}
+	if cfg.MinReadInterval < 0 || cfg.MinYaesuInterval < 0 {
+		return nil, fmt.Errorf("minimum read and yaesu update intervals must not be negative")
+	}
+	if cfg.YaesuInterval < cfg.MinYaesuInterval && !cfg.Once {
+		return nil, fmt.Errorf("yaesu update interval of %s is below the minimum of %s (see -minYaesuInterval)", time.Duration(cfg.YaesuInterval), time.Duration(cfg.MinYaesuInterval))
+	}
+	if cfg.YaesuMaxInterval != 0 && cfg.YaesuMaxInterval < cfg.YaesuInterval {
+		return nil, fmt.Errorf("maximum yaesu update interval must not be below the update interval of %s", time.Duration(cfg.YaesuInterval))
+	}
+	for _, b := range cfg.Backends {
+		switch b {
+		case backendSlack, backendDiscord:
+			if cfg.SlackToken != "" {
+				if b != backendSlack {
+					return nil, fmt.Errorf("a slack bot token can only be used with the %q backend", backendSlack)
+				}
+				if cfg.SlackChannel == "" {
+					return nil, fmt.Errorf("provide the slack channel to post to using the bot token")
+				}
+				continue
+			}
+			hosts := cfg.WebhookHosts
+			if len(hosts) == 0 {
+				hosts = defaultWebhookHosts[b]
+			}
+			if err := validateWebhook(cfg.Webhook, hosts); err != nil {
+				return nil, &configError{exitNoWebhook, fmt.Errorf("invalid webhook: %v", err)}
+			}
+		case backendTelegram:
+			if cfg.TelegramToken == "" || cfg.TelegramChat == "" {
+				return nil, fmt.Errorf("provide the telegram bot token and chat ID")
+			}
+		case backendMQTT:
+			if cfg.MQTTBroker == "" {
+				return nil, fmt.Errorf("provide the MQTT broker to publish to")
+			}
+		default:
+			return nil, fmt.Errorf("unknown backend %q, use %q, %q, %q or %q", b, backendSlack, backendDiscord, backendTelegram, backendMQTT)
+		}
+	}
+	if cfg.Since < 0 {
+		return nil, fmt.Errorf("since must not be negative")
+	}
+	for _, l := range cfg.AllowLocations {
+		if country, _, _ := strings.Cut(l, "/"); strings.TrimSpace(country) == "" {
+			return nil, fmt.Errorf("invalid location %q to allow, use country or country/state", l)
+		}
+	}
+	if cfg.Jitter < 0 || cfg.Jitter >= 1 {
+		return nil, fmt.Errorf("jitter must be a fraction between 0 and 1 (e.g. 0.1), got %g", cfg.Jitter)
+	}
+	if cfg.NodeURL != "" && !strings.HasPrefix(cfg.NodeURL, "https://") && !strings.HasPrefix(cfg.NodeURL, "http://") {
+		return nil, fmt.Errorf("invalid node URL %q, use a URL template like https://www.qrz.com/db/{callsign}", cfg.NodeURL)
+	}
+	if cfg.MaxTextLength < 0 {
+		return nil, fmt.Errorf("maximum text length must not be negative")
+	}
+	if cfg.RecentEvents < 0 {
+		return nil, fmt.Errorf("number of recent events must not be negative")
+	}
+	if cfg.SlackIcon != "" && !slackEmojiRE.MatchString(cfg.SlackIcon) {
+		if u, err := url.Parse(cfg.SlackIcon); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid slack icon %q, use an emoji like :radio: or the URL of an image", cfg.SlackIcon)
+		}
+	}
+	if cfg.SlackRate < 0 {
+		return nil, fmt.Errorf("slack rate must not be negative")
+	}
+	if cfg.SlackBurst < 1 {
+		return nil, fmt.Errorf("slack burst must be at least 1")
+	}
+	if cfg.PostWorkers < 1 {
+		return nil, fmt.Errorf("number of post workers must be at least 1")
+	}
+	for _, r := range cfg.Routes {
+		if err := validateRoute(cfg, r); err != nil {
+			return nil, fmt.Errorf("invalid route %q: %v", r.Match, err)
+		}
+	}
+	for _, f := range cfg.FilterRegexps {
+		if _, err := regexp.Compile(f); err != nil {
+			return nil, fmt.Errorf("invalid filter regexp %q: %v", f, err)
+		}
+	}
+	for _, c := range append(append([]string{}, cfg.IncludeCategories...), cfg.ExcludeCategories...) {
+		if !validCategory(c) {
+			return nil, fmt.Errorf("unknown event category %q, use one of %s", c, categoryList())
+		}
+	}
+	for c, color := range cfg.Colors {
+		if !validCategory(c) {
+			return nil, fmt.Errorf("unknown event category %q in colors, use one of %s", c, categoryList())
+		}
+		if !colorRE.MatchString(color) {
+			return nil, fmt.Errorf("invalid color %q of category %q, use good, warning, danger or a hex color like #439fe0", color, c)
+		}
+	}
+	if _, err := newProxy(cfg.Proxy); err != nil {
+		return nil, err
+	}
+	for name := range cfg.Headers {
+		if !headerNameRE.MatchString(name) {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	for name := range cfg.WebhookHeaders {
+		if !headerNameRE.MatchString(name) {
+			return nil, fmt.Errorf("invalid webhook header name %q", name)
+		}
+	}
+	for _, t := range cfg.Targets {
+		if t.Interval <= 0 {
+			return nil, fmt.Errorf("read interval of target %q must be positive", reader.Redact(t.Target))
+		}
+		if t.Interval < cfg.MinReadInterval && !cfg.Once {
+			return nil, fmt.Errorf("read interval of %s of target %q is below the minimum of %s (see -minReadInterval)", time.Duration(t.Interval), reader.Redact(t.Target), time.Duration(cfg.MinReadInterval))
+		}
+		if _, err := time.LoadLocation(t.Location); err != nil {
+			return nil, &configError{exitLocation, fmt.Errorf("invalid location %q of target %q: %v", t.Location, reader.Redact(t.Target), err)}
+		}
+		if _, err := newTLSConfig(t.TLSCA, t.TLSInsecure); err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration of target %q: %v", reader.Redact(t.Target), err)
+		}
+	}
+	return cfg, nil
+}
+
+// logSummary logs the validated configuration (the targets with their resolved location and the
+// backends posted to) to help diagnosing a deployment.
+func logSummary(cfg *Config) {
+	for _, t := range cfg.Targets {
+		target := reader.Redact(t.Target)
+		loc, _ := time.LoadLocation(t.Location) // validated in getConfig
+		zone := time.Now().In(loc).Format("MST -07:00")
+		logging.Verbosef(logging.Fields{"target": target, "interval": time.Duration(t.Interval).String(), "location": loc.String(), "zone": zone}, "Target %q is read every %s, its timestamps are in %s (%s)", target, time.Duration(t.Interval), loc, zone)
+	}
+	backends := []string{}
+	for _, b := range cfg.Backends {
+		if (b == backendSlack || b == backendDiscord) && cfg.SlackToken == "" {
+			if u, err := url.Parse(cfg.Webhook); err == nil {
+				b = fmt.Sprintf("%s (webhook on %s)", b, u.Host)
+			}
+		} else if b == backendSlack {
+			b = fmt.Sprintf("%s (bot posting to %s)", b, cfg.SlackChannel)
+		}
+		backends = append(backends, b)
+	}
+	if len(backends) == 0 {
+		backends = append(backends, "none (export only)")
+	}
+	logging.Verbosef(logging.Fields{"backends": strings.Join(backends, ", "), "routes": len(cfg.Routes)}, "Posting to %s with %d routes", strings.Join(backends, ", "), len(cfg.Routes))
+}
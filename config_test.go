@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestGetConfigZeroValues ensures that values set to 0 in the config file (e.g. to disable a
+// feature) are not replaced by the defaults of the flags.
+func TestGetConfigZeroValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{
+		"targets": [{"target": "/tmp/nodelog.html"}],
+		"webhook": "https://hooks.slack.com/services/x",
+		"slackRate": 0,
+		"jitter": 0,
+		"minReadInterval": "0s",
+		"yaesuStaleAfter": "0s",
+		"DedupWindow": "0s",
+		"maxTextLength": 0,
+		"recentEvents": 0,
+		"archiveMaxSize": 0
+	}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { *configFile = old }(*configFile)
+	*configFile = path
+
+	cfg, err := getConfig()
+	if err != nil {
+		t.Fatalf("getConfig() failed: %v", err)
+	}
+	if cfg.SlackRate != 0 || cfg.Jitter != 0 || cfg.MaxTextLength != 0 || cfg.RecentEvents != 0 || cfg.ArchiveMaxSize != 0 {
+		t.Errorf("getConfig() = slackRate %v, jitter %v, maxTextLength %d, recentEvents %d, archiveMaxSize %d, want all 0", cfg.SlackRate, cfg.Jitter, cfg.MaxTextLength, cfg.RecentEvents, cfg.ArchiveMaxSize)
+	}
+	if cfg.MinReadInterval != 0 || cfg.YaesuStaleAfter != 0 || cfg.DedupWindow != 0 {
+		t.Errorf("getConfig() = minReadInterval %s, yaesuStaleAfter %s, dedupWindow %s, want all 0", time.Duration(cfg.MinReadInterval), time.Duration(cfg.YaesuStaleAfter), time.Duration(cfg.DedupWindow))
+	}
+	// Keys which are not set still default to the flags.
+	if cfg.MinYaesuInterval != Duration(*minYaesuInterval) || cfg.SlackBurst != *slackBurst {
+		t.Errorf("getConfig() = minYaesuInterval %s, slackBurst %d, want the flag defaults", time.Duration(cfg.MinYaesuInterval), cfg.SlackBurst)
+	}
+}
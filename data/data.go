@@ -2,6 +2,7 @@ package data
 
 import (
 	"encoding/json"
+	"strings"
 	"time"
 )
 
@@ -15,27 +16,66 @@ func (a ByAge) Less(i, j int) bool { return a[i].Ts.Before(a[j].Ts) }
 // Log represents a Wires-X log.
 type Log struct {
 	// Source is where the log was polled from.
-	Source string
+	Source string `json:"source"`
 	// Type defines what log this is (i.e. node log, room log, etd).
-	Type string
+	Type string `json:"type"`
 	// ID is the idenfitier for the node or room this log is for.
-	ID string
+	ID string `json:"id"`
 	// WiresVersion exposes the Wires-X software version of the server.
-	WiresVersion string
+	WiresVersion string `json:"wiresVersion"`
 
 	// Specific to Node Log
 	// ConnectedTo is the node, the repeater is connected to.
-	ConnectedTo string
+	ConnectedTo string `json:"connectedTo,omitempty"`
+	// Freq is the frequency of the node as reported on the log page, if any.
+	Freq string `json:"freq,omitempty"`
+	// Status is the status of the node as reported on the log page, if any.
+	Status string `json:"status,omitempty"`
 
 	// Events are all the events listed in the log.
-	Events []*Event
+	Events []*Event `json:"events"`
+}
+
+// IsRoom returns true if this is the log of a room (as told by its Type), false for the log of a
+// node.
+func (l *Log) IsRoom() bool {
+	return strings.Contains(strings.ToLower(l.Type), "room")
+}
+
+// Category is the category of an event, detected from its message.
+type Category string
+
+// Event categories.
+const (
+	CategoryCallStart    Category = "call-start"
+	CategoryInCall       Category = "in-call"
+	CategoryConnected    Category = "connected"
+	CategoryDisconnected Category = "disconnected"
+	CategoryRoomIn       Category = "room-in"
+	CategoryRoomOut      Category = "room-out"
+	CategoryOther        Category = "other"
+)
+
+// Categories are all known event categories.
+var Categories = []Category{
+	CategoryCallStart,
+	CategoryInCall,
+	CategoryConnected,
+	CategoryDisconnected,
+	CategoryRoomIn,
+	CategoryRoomOut,
+	CategoryOther,
 }
 
 // Event represents a Wires-X log event / log line.
 type Event struct {
-	Raw string
-	Ts  time.Time
-	Msg string
+	Raw string    `json:"raw"`
+	Ts  time.Time `json:"ts"`
+	Msg string    `json:"msg"`
+	// Category is detected once when parsing the log.
+	Category Category `json:"category"`
+	// TsInvalid is true if the timestamp could not be parsed, Ts is zero in this case.
+	TsInvalid bool `json:"tsInvalid,omitempty"`
 }
 
 // ActiveRooms represents the Active Rooms list provided by Yaesu.
@@ -78,8 +118,9 @@ type Location struct {
 	City    string
 	State   string
 	Country string
-	Lat     string
-	Lon     string
+	// Lat and Lon are the coordinates in decimal degrees. Both are 0 if unknown.
+	Lat float64
+	Lon float64
 }
 
 type Attachment struct {
@@ -103,7 +144,7 @@ type Attachment struct {
 	ImageURL string `json:"image_url,omitempty"`
 	ThumbURL string `json:"thumb_url,omitempty"`
 
-	//Fields     []AttachmentField  `json:"fields,omitempty"`
+	Fields []AttachmentField `json:"fields,omitempty"`
 	//Actions    []AttachmentAction `json:"actions,omitempty"`
 	MarkdownIn []string `json:"mrkdwn_in,omitempty"`
 
@@ -113,7 +154,25 @@ type Attachment struct {
 	Ts json.Number `json:"ts,omitempty"`
 }
 
+// AttachmentField is a field of an attachment, rendered in a grid if Short is true.
+type AttachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
 type Message struct {
 	Text        string       `json:"text,omitempty"`
 	Attachments []Attachment `json:"attachments,omitempty"`
+	// ThreadTS is the ts of the parent message to post this message as a reply in its thread.
+	ThreadTS string `json:"thread_ts,omitempty"`
+	// Channel overrides the channel the message is posted to. Webhooks only accept this if they
+	// are configured to allow overriding the channel.
+	Channel string `json:"channel,omitempty"`
+	// Username, IconEmoji and IconURL override the name and icon the message is posted with.
+	Username  string `json:"username,omitempty"`
+	IconEmoji string `json:"icon_emoji,omitempty"`
+	IconURL   string `json:"icon_url,omitempty"`
+	// LogID is the ID of the log (node or room) the message is about. It is not posted.
+	LogID string `json:"-"`
 }
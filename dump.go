@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/resolver"
+)
+
+var (
+	// nodesCSVHeader are the columns of the nodes written by dump-nodes.
+	nodesCSVHeader = []string{"id", "dtmf_id", "callsign", "mode", "city", "state", "country", "lat", "lon", "freq", "sql", "comment"}
+	// roomsCSVHeader are the columns of the rooms written by dump-rooms.
+	roomsCSVHeader = []string{"id", "dtmf_id", "name", "activity", "city", "state", "country", "lat", "lon", "comment"}
+)
+
+// locationColumns returns the city, state, country, lat and lon columns of l, the coordinates
+// being empty if unknown.
+func locationColumns(l *data.Location) []string {
+	if l == nil {
+		return []string{"", "", "", "", ""}
+	}
+	lat, lon := "", ""
+	if l.Lat != 0 || l.Lon != 0 {
+		lat = strconv.FormatFloat(l.Lat, 'f', 6, 64)
+		lon = strconv.FormatFloat(l.Lon, 'f', 6, 64)
+	}
+	return []string{l.City, l.State, l.Country, lat, lon}
+}
+
+// writeNodesCSV writes the nodes to w as CSV with a header line.
+func writeNodesCSV(w io.Writer, nodes []*data.Node) error {
+	cw := csv.NewWriter(w)
+	cw.Write(nodesCSVHeader)
+	for _, n := range nodes {
+		row := []string{n.ID, n.DTMFID, n.Callsign, n.Mode}
+		row = append(row, locationColumns(n.Location)...)
+		row = append(row, n.Freq, n.SQL, n.Comment)
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeRoomsCSV writes the rooms to w as CSV with a header line.
+func writeRoomsCSV(w io.Writer, rooms []*data.Room) error {
+	cw := csv.NewWriter(w)
+	cw.Write(roomsCSVHeader)
+	for _, r := range rooms {
+		row := []string{r.ID, r.DTMFID, r.Name, r.Act}
+		row = append(row, locationColumns(r.Location)...)
+		row = append(row, r.Comment)
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// dump implements the dump-nodes and dump-rooms subcommands: it updates the Yaesu active nodes and
+// rooms lists once, writes all nodes or rooms and returns the exit code.
+func dump(cmd string, args []string) int {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	format := fs.String("format", "csv", "format to write the list in (csv)")
+	output := fs.String("output", "-", "file to write the list to, - for stdout")
+	yaesu := addYaesuFlags(fs)
+	v := fs.Bool("v", false, "log more detailed messages")
+	list := "nodes"
+	if cmd == "dump-rooms" {
+		list = "rooms"
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [flags]\n\nWrite all %s of the Yaesu active list, e.g. to build maps.\n\n", os.Args[0], cmd, list)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *format != "csv" {
+		fmt.Fprintf(os.Stderr, "unsupported format %q, must be csv\n", *format)
+		return 2
+	}
+
+	if err := yaesu.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	// Only one of the lists is needed, the other one may fail.
+	updateErr := resolver.Update(*v)
+	var write func(w io.Writer) error
+	switch list {
+	case "nodes":
+		an := resolver.ActiveNodesSnapshot()
+		if an == nil {
+			fmt.Fprintf(os.Stderr, "unable to update the Yaesu active nodes list: %v\n", updateErr)
+			return 1
+		}
+		write = func(w io.Writer) error { return writeNodesCSV(w, an.Nodes) }
+	case "rooms":
+		ar := resolver.ActiveRoomsSnapshot()
+		if ar == nil {
+			fmt.Fprintf(os.Stderr, "unable to update the Yaesu active rooms list: %v\n", updateErr)
+			return 1
+		}
+		write = func(w io.Writer) error { return writeRoomsCSV(w, ar.Rooms) }
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to create %q: %v\n", *output, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := write(w); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to write the %s: %v\n", list, err)
+		return 1
+	}
+	return 0
+}
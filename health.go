@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/hb9tf/wireslacker/resolver"
+)
+
+var (
+	// readiness tracks which targets have been polled at least once.
+	readiness = &health{
+		pending: map[string]bool{},
+	}
+)
+
+// health tracks whether wireslacker is ready to do its job.
+type health struct {
+	mu sync.Mutex
+	// pending are the (redacted) targets which have not been polled yet.
+	pending map[string]bool
+}
+
+// expect registers a target which has to be polled before being ready.
+func (h *health) expect(target string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pending[target] = true
+}
+
+// polled records that the target has been polled, successfully or not.
+func (h *health) polled(target string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.pending, target)
+}
+
+// ready returns nil once the Yaesu lists have been loaded and all targets have been polled,
+// or an error explaining what is still missing.
+func (h *health) ready() error {
+	_, nodes := resolver.NodesAge()
+	_, rooms := resolver.RoomsAge()
+	if !nodes && !rooms {
+		return fmt.Errorf("yaesu active nodes and rooms lists not loaded yet")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.pending) > 0 {
+		var targets []string
+		for t := range h.pending {
+			targets = append(targets, t)
+		}
+		return fmt.Errorf("targets not polled yet: %s", strings.Join(targets, ", "))
+	}
+	return nil
+}
+
+// handleHealthz reports the liveness of the process.
+func (h *health) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether wireslacker is ready (see ready).
+func (h *health) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if err := h.ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// FormatText logs human readable lines using the standard log package.
+	FormatText = "text"
+	// FormatJSON logs one JSON object per line.
+	FormatJSON = "json"
+
+	levelVerbose = "verbose"
+	levelInfo    = "info"
+	levelError   = "error"
+
+	// verbosePrefix is prepended to verbose messages in text format.
+	verbosePrefix = "V: "
+)
+
+var (
+	logFormat   = FormatText
+	logFormatMu = &sync.RWMutex{}
+
+	// jsonLogger writes the JSON records without any prefix or timestamp of its own.
+	jsonLogger = log.New(os.Stderr, "", 0)
+)
+
+// Fields are structured key/value pairs attached to a log record (e.g. target, event_count,
+// error). They are only emitted in JSON format as the text messages already contain them.
+type Fields map[string]interface{}
+
+// SetFormat sets the output format of all log records, either FormatText or FormatJSON.
+func SetFormat(f string) error {
+	switch f {
+	case FormatText, FormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q, use %q or %q", f, FormatText, FormatJSON)
+	}
+	logFormatMu.Lock()
+	defer logFormatMu.Unlock()
+	logFormat = f
+	return nil
+}
+
+func output(level string, fields Fields, msg string) {
+	logFormatMu.RLock()
+	f := logFormat
+	logFormatMu.RUnlock()
+
+	if f == FormatText {
+		if level == levelVerbose {
+			msg = verbosePrefix + msg
+		}
+		log.Print(msg)
+		return
+	}
+
+	record := map[string]interface{}{}
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		record[k] = v
+	}
+	record["time"] = time.Now().Format(time.RFC3339Nano)
+	record["level"] = level
+	record["msg"] = msg
+	b, err := json.Marshal(record)
+	if err != nil {
+		b, _ = json.Marshal(map[string]string{
+			"time":  time.Now().Format(time.RFC3339Nano),
+			"level": levelError,
+			"msg":   fmt.Sprintf("unable to marshal log record %q: %v", msg, err),
+		})
+	}
+	jsonLogger.Print(string(b))
+}
+
+// Verbosef logs a detailed message. Callers are expected to only call it in verbose mode.
+func Verbosef(fields Fields, format string, v ...interface{}) {
+	output(levelVerbose, fields, fmt.Sprintf(format, v...))
+}
+
+// Infof logs an informational message.
+func Infof(fields Fields, format string, v ...interface{}) {
+	output(levelInfo, fields, fmt.Sprintf(format, v...))
+}
+
+// Errorf logs an error.
+func Errorf(fields Fields, format string, v ...interface{}) {
+	output(levelError, fields, fmt.Sprintf(format, v...))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/resolver"
+)
+
+// formatLocation renders the location for the lookup output.
+func formatLocation(l *data.Location) string {
+	if l == nil {
+		return "n/a"
+	}
+	loc := fmt.Sprintf("%s, %s, %s", l.City, l.State, l.Country)
+	if l.Lat != 0 || l.Lon != 0 {
+		loc = fmt.Sprintf("%s (%f, %f)", loc, l.Lat, l.Lon)
+	}
+	return loc
+}
+
+// printNode writes the details of the node to w.
+func printNode(w io.Writer, n *data.Node) {
+	fmt.Fprintf(w, "Node %s (DTMF ID %s)\n", n.ID, n.DTMFID)
+	fmt.Fprintf(w, "  Callsign:  %s\n", n.Callsign)
+	fmt.Fprintf(w, "  Mode:      %s\n", n.Mode)
+	fmt.Fprintf(w, "  Frequency: %s (%s)\n", n.Freq, n.SQL)
+	fmt.Fprintf(w, "  Location:  %s\n", formatLocation(n.Location))
+	fmt.Fprintf(w, "  Comment:   %s\n", n.Comment)
+}
+
+// printRoom writes the details of the room to w.
+func printRoom(w io.Writer, r *data.Room) {
+	fmt.Fprintf(w, "Room %s (DTMF ID %s)\n", r.ID, r.DTMFID)
+	fmt.Fprintf(w, "  Name:      %s\n", r.Name)
+	fmt.Fprintf(w, "  Location:  %s\n", formatLocation(r.Location))
+	fmt.Fprintf(w, "  Comment:   %s\n", r.Comment)
+}
+
+// yaesuFlags are the flags of the subcommands defining how the Yaesu active nodes and rooms
+// lists are read, matching the ones of the main command.
+type yaesuFlags struct {
+	nodesURL    *string
+	roomsURL    *string
+	timeout     *time.Duration
+	headers     stringList
+	proxy       *string
+	tlsCA       *string
+	tlsInsecure *bool
+}
+
+// addYaesuFlags defines the flags reading the Yaesu lists in fs.
+func addYaesuFlags(fs *flag.FlagSet) *yaesuFlags {
+	f := &yaesuFlags{
+		nodesURL:    fs.String("yaesuNodesURL", resolver.DefaultNodesURL, "URL of the Yaesu active nodes list"),
+		roomsURL:    fs.String("yaesuRoomsURL", resolver.DefaultRoomsURL, "URL of the Yaesu active rooms list"),
+		timeout:     fs.Duration("yaesuTimeout", 30*time.Second, "how long to wait for the Yaesu active nodes and rooms lists to respond"),
+		proxy:       fs.String("proxy", "", "URL of the proxy to read the Yaesu lists through (e.g. http://host:3128), the one configured in the environment (HTTPS_PROXY, HTTP_PROXY) if empty"),
+		tlsCA:       fs.String("tlsCA", "", "path to a PEM file of additional CAs to trust for the Yaesu lists (e.g. for a mirror with a self-signed certificate)"),
+		tlsInsecure: fs.Bool("tlsInsecure", false, "do not verify the TLS certificate of the Yaesu lists - insecure, prefer -tlsCA"),
+	}
+	fs.Var(&f.headers, "header", "additional header to send to the Yaesu lists as \"Name: value\", e.g. to override the User-Agent (can be repeated)")
+	return f
+}
+
+// apply configures the resolver to read the Yaesu lists as defined by the flags.
+func (f *yaesuFlags) apply() error {
+	headers, err := parseHeaders(f.headers)
+	if err != nil {
+		return err
+	}
+	proxyURL, err := newProxy(*f.proxy)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := newTLSConfig(*f.tlsCA, *f.tlsInsecure)
+	if err != nil {
+		return fmt.Errorf("invalid TLS configuration: %v", err)
+	}
+	header := http.Header{"User-Agent": {"wireslacker/" + version}}
+	for name, value := range headers {
+		header.Set(name, value)
+	}
+	resolver.SetHeader(header)
+	resolver.SetProxy(proxyURL)
+	resolver.SetTLSConfig(tlsConfig)
+	resolver.SetHTTPTimeout(*f.timeout)
+	resolver.SetURLs(*f.nodesURL, *f.roomsURL)
+	return nil
+}
+
+// lookup implements the lookup subcommand: it updates the Yaesu active nodes and rooms lists
+// once, prints the matching nodes and rooms and returns the exit code.
+func lookup(args []string) int {
+	fs := flag.NewFlagSet("lookup", flag.ExitOnError)
+	callsign := fs.String("callsign", "", "callsign of the node to look up")
+	fuzzy := fs.Bool("fuzzy", false, "also match callsigns which differ in suffixes (e.g. HB9TF-ND for HB9TF) if there is no exact match")
+	id := fs.String("id", "", "ID of the node or room to look up")
+	dtmfID := fs.String("dtmf", "", "DTMF ID of the node or room to look up")
+	name := fs.String("room", "", "name of the room to look up")
+	yaesu := addYaesuFlags(fs)
+	v := fs.Bool("v", false, "log more detailed messages")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s lookup [flags]\n\nLook up nodes and rooms in the Yaesu active lists.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *callsign == "" && *id == "" && *dtmfID == "" && *name == "" {
+		fs.Usage()
+		return 2
+	}
+
+	if err := yaesu.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	// Look up what could be updated, e.g. the rooms if only the nodes list failed.
+	if err := resolver.Update(*v); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to update the Yaesu active nodes and rooms lists: %v\n", err)
+	}
+
+	nodes := resolver.FindNodes(*id, *dtmfID, *callsign)
+	if len(nodes) == 0 && *callsign != "" {
+		if n := resolver.FindNodeByCallsign(*callsign, *fuzzy); n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+	rooms := resolver.FindRooms(*id, *dtmfID, *name)
+	if len(nodes) == 0 && len(rooms) == 0 {
+		fmt.Fprintln(os.Stderr, "no matching node or room found")
+		return 1
+	}
+	for _, n := range nodes {
+		printNode(os.Stdout, n)
+	}
+	for _, r := range rooms {
+		printRoom(os.Stdout, r)
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+
+	// namespace is prepended to all metric names.
+	namespace = "wireslacker_"
+)
+
+var (
+	// registry contains all metrics which have been created, keyed by name.
+	registry   = map[string]*vec{}
+	registryMu = &sync.RWMutex{}
+
+	labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+)
+
+// vec is a metric with an optional set of labels, exposed in the Prometheus text format.
+type vec struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	v := &vec{
+		name:   namespace + name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		values: map[string]float64{},
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[v.name]; ok {
+		panic(fmt.Sprintf("metric %q registered twice", v.name))
+	}
+	registry[v.name] = v
+	return v
+}
+
+// key renders the label set for the provided label values.
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %q expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	if len(v.labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(v.labels))
+	for i, l := range v.labels {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", l, labelEscaper.Replace(labelValues[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	k := v.key(labelValues)
+	v.mu.Lock()
+	v.values[k] += delta
+	v.mu.Unlock()
+}
+
+func (v *vec) set(val float64, labelValues []string) {
+	k := v.key(labelValues)
+	v.mu.Lock()
+	v.values[k] = val
+	v.mu.Unlock()
+}
+
+// write renders the metric in the Prometheus text format.
+func (v *vec) write(sb *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	fmt.Fprintf(sb, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(sb, "# TYPE %s %s\n", v.name, v.typ)
+	keys := make([]string, 0, len(v.values))
+	for k := range v.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(sb, "%s%s %s\n", v.name, k, strconv.FormatFloat(v.values[k], 'g', -1, 64))
+	}
+}
+
+// Counter is a monotonically increasing metric.
+type Counter struct {
+	v *vec
+}
+
+// NewCounter creates and registers a new counter with the provided label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{newVec(name, help, typeCounter, labels)}
+}
+
+// Inc increments the counter for the provided label values by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.v.add(1, labelValues)
+}
+
+// Add increments the counter for the provided label values by delta.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %q can not decrease", c.v.name))
+	}
+	c.v.add(delta, labelValues)
+}
+
+// Gauge is a metric which can arbitrarily go up and down.
+type Gauge struct {
+	v *vec
+}
+
+// NewGauge creates and registers a new gauge with the provided label names.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{newVec(name, help, typeGauge, labels)}
+}
+
+// Set sets the gauge for the provided label values to val.
+func (g *Gauge) Set(val float64, labelValues ...string) {
+	g.v.set(val, labelValues)
+}
+
+// Handler returns an http.Handler which exposes all registered metrics in the
+// Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryMu.RLock()
+		names := make([]string, 0, len(registry))
+		for n := range registry {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		sb := &strings.Builder{}
+		for _, n := range names {
+			registry[n].write(sb)
+		}
+		registryMu.RUnlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(sb.String()))
+	})
+}
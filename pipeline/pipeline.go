@@ -0,0 +1,183 @@
+// Package pipeline runs the whole wireslacker pipeline: it reads the Wires-X logs of all targets
+// and posts their new events using the processor. Use it to embed wireslacker in another program.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/logging"
+	"github.com/hb9tf/wireslacker/processor"
+	"github.com/hb9tf/wireslacker/reader"
+)
+
+// Target is a Wires-X log to read.
+type Target struct {
+	// Target is the path or URL of the log (see reader.New).
+	Target string
+	// Interval is the interval in which to read the log.
+	Interval time.Duration
+	// Options are the options to read the log with.
+	Options reader.Options
+	// Location is the location of the Wires-X server serving the log, time.Local if nil.
+	Location *time.Location
+}
+
+// Config configures a Pipeline.
+type Config struct {
+	// Targets are the logs to read.
+	Targets []*Target
+	// Notifiers are the notifiers to post the events to (see processor.Run).
+	Notifiers []processor.Notifier
+	// Processor configures how the events are filtered, enriched and posted.
+	Processor processor.Config
+	// State records the last posted event per log source, an in-memory state is used if nil.
+	State *processor.State
+	// AlertAfter is how long a target has to be unreachable before an alert is posted to the
+	// notifiers of its events. Disabled if 0.
+	AlertAfter time.Duration
+	// Jitter is the fraction by which the interval between two polls of a target is randomly
+	// varied (e.g. 0.1 for ±10%) so the targets are not all polled at the same moment. Disabled
+	// if 0.
+	Jitter float64
+	// Once reads each target a single time instead of polling it. The pipeline stops on its own
+	// once all logs have been processed.
+	Once bool
+	// Polled is called with the (redacted) target after each poll, successful or not, e.g. to
+	// track readiness. Optional.
+	Polled func(target string)
+	// Verbose logs more detailed messages.
+	Verbose bool
+}
+
+// Pipeline reads the logs of all targets and posts their new events.
+type Pipeline struct {
+	cfg     Config
+	readers []reader.Log
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	// done is closed once all logs read have been processed.
+	done chan struct{}
+	// failed is set if a target could not be read in Once mode.
+	failed atomic.Bool
+}
+
+// New creates a Pipeline for the provided config, creating the readers of all targets.
+func New(cfg Config) (*Pipeline, error) {
+	if len(cfg.Targets) == 0 {
+		return nil, errors.New("no targets to read")
+	}
+	p := &Pipeline{cfg: cfg}
+	for _, t := range cfg.Targets {
+		if t.Interval <= 0 && !cfg.Once {
+			return nil, fmt.Errorf("read interval of target %q must be positive", reader.Redact(t.Target))
+		}
+		loc := t.Location
+		if loc == nil {
+			loc = time.Local
+		}
+		r, err := reader.New(t.Target, t.Options, loc, cfg.Verbose)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get reader for target %q: %v", reader.Redact(t.Target), err)
+		}
+		p.readers = append(p.readers, r)
+	}
+	if p.cfg.State == nil {
+		p.cfg.State, _ = processor.LoadState("") // never fails without a path
+	}
+	return p, nil
+}
+
+// Start starts reading all targets and processing their logs in the background until ctx is
+// cancelled or Stop is called. A Pipeline can only be started once.
+func (p *Pipeline) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done != nil {
+		return errors.New("pipeline already started")
+	}
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.done = make(chan struct{})
+
+	logChan := make(chan *data.Log)
+	processed := make(chan struct{})
+	go func() {
+		processor.Run(logChan, p.cfg.Notifiers, p.cfg.State, p.cfg.Processor, p.cfg.Verbose)
+		close(processed)
+	}()
+
+	// Start a reader for each target.
+	var wg sync.WaitGroup
+	for i, t := range p.cfg.Targets {
+		wg.Add(1)
+		go func(t *Target, r reader.Log) {
+			defer wg.Done()
+			p.read(ctx, t, r, logChan)
+		}(t, p.readers[i])
+	}
+	go func() {
+		wg.Wait()
+		// Wait for all logs read to be processed.
+		close(logChan)
+		<-processed
+		close(p.done)
+	}()
+	return nil
+}
+
+// read reads the log of target t using r until ctx is cancelled, or once in Once mode.
+func (p *Pipeline) read(ctx context.Context, t *Target, r reader.Log, logChan chan *data.Log) {
+	target := reader.Redact(t.Target)
+	if p.cfg.Once {
+		if err := p.readOnce(ctx, r, target, logChan); err != nil {
+			logging.Errorf(logging.Fields{"target": target, "error": err}, "Unable to read log %q: %v", target, err)
+			p.failed.Store(true)
+		}
+		return
+	}
+	logging.Infof(logging.Fields{"target": target}, "Start polling %q", target)
+	targetNotifiers, ok := p.cfg.Processor.Routes[reader.Source(t.Target)]
+	if !ok {
+		targetNotifiers = p.cfg.Notifiers
+	}
+	alert := func(text string) {
+		processor.Alert(targetNotifiers, text)
+	}
+	p.readEvery(ctx, t.Interval, r, target, logChan, alert)
+	logging.Infof(logging.Fields{"target": target}, "Stop polling %q", target)
+}
+
+// Stop stops reading the targets and waits for the logs already read to be processed.
+func (p *Pipeline) Stop() {
+	p.mu.Lock()
+	cancel, done := p.cancel, p.done
+	p.mu.Unlock()
+	if cancel == nil {
+		return // not started
+	}
+	cancel()
+	<-done
+}
+
+// Wait blocks until the pipeline stopped, either because Stop was called, its context was
+// cancelled or (in Once mode) all targets have been read and processed. It returns an error if a
+// target could not be read in Once mode.
+func (p *Pipeline) Wait() error {
+	p.mu.Lock()
+	done := p.done
+	p.mu.Unlock()
+	if done == nil {
+		return errors.New("pipeline not started")
+	}
+	<-done
+	if p.failed.Load() {
+		return errors.New("unable to read all targets")
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/logging"
+	"github.com/hb9tf/wireslacker/metrics"
+	"github.com/hb9tf/wireslacker/reader"
+	"github.com/hb9tf/wireslacker/resolver"
+)
+
+var (
+	// maxReadBackoff caps the interval in which an unreachable target is polled, unless its own
+	// interval is longer.
+	maxReadBackoff = time.Duration(15 * time.Minute)
+
+	pollsTotal      = metrics.NewCounter("polls_total", "Number of polls per target.", "target")
+	pollErrorsTotal = metrics.NewCounter("poll_errors_total", "Number of failed polls per target.", "target")
+)
+
+// polled reports the poll of target to the Polled callback, if any.
+func (p *Pipeline) polled(target string) {
+	if p.cfg.Polled != nil {
+		p.cfg.Polled(target)
+	}
+}
+
+// poll uses the provided reader to read the log from target and sends each data.Log (one per node
+// or room on the page) to the logChan.
+func (p *Pipeline) poll(ctx context.Context, r reader.Log, target string, logChan chan *data.Log) error {
+	if p.cfg.Verbose {
+		logging.Verbosef(logging.Fields{"target": target}, "Polling log %q", target)
+	}
+	pollsTotal.Inc(target)
+	evtLogs, err := r.ReadAll(ctx)
+	p.polled(target)
+	if err != nil {
+		if ctx.Err() == nil {
+			pollErrorsTotal.Inc(target)
+		}
+		return err
+	}
+	for _, evtLog := range evtLogs {
+		select {
+		case logChan <- evtLog:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// stream receives the log from the streaming reader and sends the data.Log of each event to the
+// logChan until the connection is lost or ctx is cancelled.
+func (p *Pipeline) stream(ctx context.Context, s reader.Stream, target string, logChan chan *data.Log) error {
+	if p.cfg.Verbose {
+		logging.Verbosef(logging.Fields{"target": target}, "Streaming log %q", target)
+	}
+	pollsTotal.Inc(target)
+	p.polled(target) // connecting to a stream counts as polling it
+	if err := s.Stream(ctx, logChan); err != nil {
+		if ctx.Err() == nil {
+			pollErrorsTotal.Inc(target)
+		}
+		return err
+	}
+	return nil
+}
+
+// readOnce reads the Wires-X log from target once using r and sends the parsed log to the
+// provided logChan for further processing. Streamed logs are read until the read timeout.
+func (p *Pipeline) readOnce(ctx context.Context, r reader.Log, target string, logChan chan *data.Log) error {
+	return p.poll(ctx, r, target, logChan)
+}
+
+// readEvery reads the Wires-X log from target using r every d and sends the parsed log to the
+// provided logChan for further processing until ctx is cancelled.
+// Logs which are streamed are received as they happen and reconnected to after d.
+// On consecutive failures, the interval is doubled up to maxReadBackoff (or d if longer)
+// and reset to d on the first success.
+// The intervals are varied by Config.Jitter.
+// If Config.AlertAfter is not 0, alert is called once the target failed for at least this long
+// and again once it recovers.
+// Errors are only logged as they are retried.
+func (p *Pipeline) readEvery(ctx context.Context, d time.Duration, r reader.Log, target string, logChan chan *data.Log, alert func(text string)) {
+	poll := func() error {
+		return p.poll(ctx, r, target, logChan)
+	}
+	s, streaming := r.(reader.Stream)
+	if streaming {
+		poll = func() error {
+			return p.stream(ctx, s, target, logChan)
+		}
+	}
+
+	maxWait := maxReadBackoff
+	if d > maxWait {
+		maxWait = d
+	}
+	wait := d
+	failures := 0
+	// failingSince is the start of the first of the consecutive failed polls.
+	var failingSince time.Time
+	alerted := false
+	recovered := func() {
+		if alerted {
+			alert(fmt.Sprintf("Target %s is reachable again after %s.", target, time.Since(failingSince).Round(time.Second)))
+			alerted = false
+		}
+	}
+	for {
+		start := time.Now()
+		err := poll()
+		if streaming && time.Since(start) >= d {
+			// The stream has been up for a while, this is not a consecutive failure.
+			failures, wait = 0, d
+			recovered()
+		}
+		if err != nil && ctx.Err() == nil {
+			if failures == 0 {
+				failingSince = start
+			}
+			failures++
+			if failures > 1 {
+				if wait *= 2; wait > maxWait {
+					wait = maxWait
+				}
+			}
+			// we don't want to abort in this case and retry later
+			logging.Errorf(logging.Fields{"target": target, "failures": failures, "error": err}, "Unable to poll log %q (%d consecutive failures, retrying in %s): %v", target, failures, wait, err)
+			if down := time.Since(failingSince); p.cfg.AlertAfter > 0 && !alerted && down >= p.cfg.AlertAfter {
+				alert(fmt.Sprintf("Target %s is unreachable for %s: %v", target, down.Round(time.Second), err))
+				alerted = true
+			}
+		} else if err == nil {
+			if failures > 0 {
+				logging.Infof(logging.Fields{"target": target, "failures": failures}, "Polling log %q succeeded again after %d failures", target, failures)
+			}
+			recovered()
+			failures = 0
+			wait = d
+		}
+		// Keep the interval between the start of two polls.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(resolver.Jittered(wait, p.cfg.Jitter) - time.Since(start)):
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+)
+
+const (
+	// archiveWindow is how long archived events are remembered so events which are read again
+	// (e.g. because posting them failed and they are retried) are only archived once.
+	archiveWindow = 24 * time.Hour
+
+	// archiveDayFormat is the format of the day the events of an archive file are from.
+	archiveDayFormat = "2006-01-02"
+	// archiveRotatedFormat is the format of the time suffixed to the name of rotated files.
+	archiveRotatedFormat = "20060102-150405"
+)
+
+// NewArchive opens the archive file at path to append to, creating it if needed. The file is
+// rotated once it would exceed maxSize bytes (disabled if 0) and, if daily, before the first event
+// of each day. Rotated files are renamed to the path suffixed by the time of the rotation.
+func NewArchive(path string, maxSize int64, daily bool) (*Archive, error) {
+	a := &Archive{
+		path:    path,
+		maxSize: maxSize,
+		daily:   daily,
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Archive appends each new event (with the log it is from and its enriched attachment) as a line
+// of JSON to a file, rotated by size or day. Events are archived regardless of whether they could
+// be posted.
+type Archive struct {
+	path    string
+	maxSize int64
+	daily   bool
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	// day is the day of the events in the current file.
+	day string
+}
+
+// open opens the file at the path of the archive to append to.
+func (a *Archive) open() error {
+	f, err := os.OpenFile(a.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f, a.size, a.day = f, fi.Size(), fi.ModTime().Format(archiveDayFormat)
+	return nil
+}
+
+// rotate renames the current file and opens a new one.
+func (a *Archive) rotate(ts time.Time) error {
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+	rotated := fmt.Sprintf("%s.%s", a.path, ts.Format(archiveRotatedFormat))
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = fmt.Sprintf("%s.%s-%d", a.path, ts.Format(archiveRotatedFormat), i)
+	}
+	if err := os.Rename(a.path, rotated); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+// Add appends the event of evtLog and the attachment it is posted as to the archive, rotating
+// the file first if needed.
+func (a *Archive) Add(evtLog *data.Log, evt *data.Event, attachment data.Attachment) error {
+	line, err := json.Marshal(&RecentEvent{evtLog.Source, evtLog.ID, evtLog.Type, evt, attachment})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ts := now()
+	full := a.maxSize > 0 && a.size+int64(len(line)) > a.maxSize
+	newDay := a.daily && a.day != ts.Format(archiveDayFormat)
+	if a.size > 0 && (full || newDay) {
+		if err := a.rotate(ts); err != nil {
+			return fmt.Errorf("unable to rotate archive %q: %v", a.path, err)
+		}
+	}
+	a.day = ts.Format(archiveDayFormat)
+	n, err := a.f.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// Close closes the current file of the archive.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.f.Close()
+}
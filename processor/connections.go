@@ -0,0 +1,47 @@
+package processor
+
+import (
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/resolver"
+)
+
+// connection is what the node of a log is connected to, as tracked from its connected and
+// disconnected events.
+type connection struct {
+	// peer is the number of the node or room connected to, empty if disconnected.
+	peer string
+	// since is when the node connected to peer, or disconnected.
+	since time.Time
+}
+
+// connections tracks the connection of the node of each log, keyed by the log ID.
+type connections map[string]connection
+
+// observe updates the connection of the node of evtLog with its (sorted) events and returns how
+// long the connection lasted for each of its disconnected events, if known.
+// As the log is read repeatedly, its events are replayed from the start of the page each time:
+// the connection tracked from previous logs only matters if the connected event is no longer on
+// the page (e.g. for long connections).
+func (c connections) observe(evtLog *data.Log) map[*data.Event]time.Duration {
+	durations := map[*data.Event]time.Duration{}
+	cur := c[evtLog.ID]
+	for _, evt := range evtLog.Events {
+		switch evt.Category {
+		case data.CategoryConnected:
+			if match := connectedToRE.FindStringSubmatch(evt.Msg); len(match) > 2 {
+				cur = connection{match[2], evt.Ts}
+			}
+		case data.CategoryDisconnected:
+			if match := disconnectRE.FindStringSubmatch(evt.Msg); len(match) > 2 {
+				if resolver.SameNumber(cur.peer, match[2]) && !evt.Ts.Before(cur.since) {
+					durations[evt] = evt.Ts.Sub(cur.since)
+				}
+				cur = connection{"", evt.Ts}
+			}
+		}
+	}
+	c[evtLog.ID] = cur
+	return durations
+}
@@ -0,0 +1,99 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+)
+
+// maxDedupEntries caps the number of posted events remembered for deduplication, the oldest are
+// forgotten first.
+const maxDedupEntries = 10000
+
+// dedup remembers a hash of the content of the posted events for a sliding window to drop
+// events which have already been posted: the same event read again (e.g. from a replayed log)
+// or from another source (e.g. a node polled directly and through a mirror).
+type dedup struct {
+	window time.Duration
+	// posted maps the key of each posted event to when it was posted.
+	posted map[string]time.Time
+	// order are the keys in the order they were posted to forget the oldest first.
+	order []dedupEntry
+	// last maps each log (see logKey) to the timestamp of its last posted event.
+	last map[string]dedupLast
+}
+
+type dedupEntry struct {
+	key    string
+	posted time.Time
+}
+
+type dedupLast struct {
+	ts time.Time
+	// since is when the first event with this timestamp was posted.
+	since time.Time
+}
+
+// newDedup creates a dedup remembering events for window. It returns nil (no deduplication)
+// if window is not positive.
+func newDedup(window time.Duration) *dedup {
+	if window <= 0 {
+		return nil
+	}
+	return &dedup{window, map[string]time.Time{}, nil, map[string]dedupLast{}}
+}
+
+// dedupKey identifies an event by its content, regardless of the source it was read from.
+func dedupKey(evtLog *data.Log, evt *data.Event) string {
+	h := sha256.Sum256([]byte(evtLog.ID + "\x00" + evt.Ts.UTC().Format(time.RFC3339Nano) + "\x00" + evt.Msg))
+	return hex.EncodeToString(h[:16])
+}
+
+// duplicate returns true if the event has already been posted within the window.
+func (d *dedup) duplicate(evtLog *data.Log, evt *data.Event) bool {
+	if d == nil {
+		return false
+	}
+	posted, ok := d.posted[dedupKey(evtLog, evt)]
+	return ok && now().Sub(posted) <= d.window
+}
+
+// covers returns true if all events posted from the log of evtLog with the timestamp ts are
+// still remembered. Other events sharing this timestamp can then be told apart by their content
+// instead of being dropped as not newer than the last posted event.
+func (d *dedup) covers(evtLog *data.Log, ts time.Time) bool {
+	if d == nil {
+		return false
+	}
+	l, ok := d.last[logKey(evtLog)]
+	return ok && l.ts.Equal(ts) && now().Sub(l.since) <= d.window
+}
+
+// add remembers the events as posted from the log of evtLog and forgets the ones which
+// fell out of the window or exceed maxDedupEntries.
+func (d *dedup) add(evtLog *data.Log, events []*data.Event) {
+	if d == nil {
+		return
+	}
+	ts := now()
+	for _, evt := range events {
+		key := dedupKey(evtLog, evt)
+		d.posted[key] = ts
+		d.order = append(d.order, dedupEntry{key, ts})
+	}
+	for len(d.order) > 0 && (ts.Sub(d.order[0].posted) > d.window || len(d.order) > maxDedupEntries) {
+		// The key may have been posted again since, only forget it with its last post.
+		if e := d.order[0]; d.posted[e.key].Equal(e.posted) {
+			delete(d.posted, e.key)
+		}
+		d.order = d.order[1:]
+	}
+	if len(events) > 0 {
+		last := events[len(events)-1].Ts
+		if l, ok := d.last[logKey(evtLog)]; !ok || !l.ts.Equal(last) {
+			d.last[logKey(evtLog)] = dedupLast{last, ts}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package processor
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/logging"
+)
+
+const (
+	// discordMaxEmbeds is the maximum number of embeds Discord accepts per message.
+	discordMaxEmbeds = 10
+)
+
+var (
+	// slackLinkRE is the regexp used to find Slack formatted links (<url|text>).
+	slackLinkRE = regexp.MustCompile("<([^|>]+)\\|([^>]+)>")
+
+	// discordColors maps the Slack attachment color names to Discord colors.
+	discordColors = map[string]int{
+		"good":    0x2eb886,
+		"warning": 0xdaa038,
+		"danger":  0xa30200,
+	}
+)
+
+// discordMessage is the payload of a Discord webhook.
+type discordMessage struct {
+	Content string          `json:"content,omitempty"`
+	Embeds  []*discordEmbed `json:"embeds,omitempty"`
+}
+
+type discordEmbed struct {
+	Title       string                 `json:"title,omitempty"`
+	URL         string                 `json:"url,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Color       int                    `json:"color,omitempty"`
+	Timestamp   string                 `json:"timestamp,omitempty"`
+	Fields      []*discordEmbedField   `json:"fields,omitempty"`
+	Footer      *discordEmbedFooter    `json:"footer,omitempty"`
+	Thumbnail   *discordEmbedThumbnail `json:"thumbnail,omitempty"`
+}
+
+type discordEmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type discordEmbedFooter struct {
+	Text    string `json:"text"`
+	IconURL string `json:"icon_url,omitempty"`
+}
+
+type discordEmbedThumbnail struct {
+	URL string `json:"url"`
+}
+
+// NewDiscord creates a new Discord notifier for the provided webhook.
+func NewDiscord(webhook string, dry bool, verbose bool) *Discord {
+	return &Discord{
+		webhook,
+		&http.Client{Transport: httpTransport},
+		dry,
+		verbose,
+	}
+}
+
+// Discord implements the Notifier interface and posts messages to a Discord channel using a webhook.
+type Discord struct {
+	webhook string
+	client  *http.Client
+	dry     bool
+	verbose bool
+}
+
+// discordMarkdown converts Slack formatted text into Discord markdown.
+func discordMarkdown(s string) string {
+	return slackLinkRE.ReplaceAllString(s, "[$2]($1)")
+}
+
+// discordColor converts a Slack attachment color (name or hex) into a Discord color.
+func discordColor(c string) int {
+	if v, ok := discordColors[c]; ok {
+		return v
+	}
+	v, err := strconv.ParseInt(strings.TrimPrefix(c, "#"), 16, 32)
+	if err != nil {
+		return 0
+	}
+	return int(v)
+}
+
+// toDiscord translates the message into the Discord embeds format.
+func toDiscord(msg *data.Message) *discordMessage {
+	dm := &discordMessage{
+		Content: discordMarkdown(msg.Text),
+	}
+	for _, a := range msg.Attachments {
+		if len(dm.Embeds) == discordMaxEmbeds {
+			break
+		}
+		e := &discordEmbed{
+			Title:       discordMarkdown(a.Pretext),
+			URL:         a.TitleLink,
+			Description: discordMarkdown(a.Text),
+			Color:       discordColor(a.Color),
+		}
+		if a.Title != "" {
+			e.Title = a.Title
+			e.Description = strings.TrimSpace(discordMarkdown(a.Pretext) + "\n" + e.Description)
+		}
+		if ts, err := a.Ts.Int64(); err == nil && ts > 0 {
+			e.Timestamp = time.Unix(ts, 0).UTC().Format(time.RFC3339)
+		}
+		for _, f := range a.Fields {
+			e.Fields = append(e.Fields, &discordEmbedField{
+				Name:   f.Title,
+				Value:  discordMarkdown(f.Value),
+				Inline: f.Short,
+			})
+		}
+		if a.Footer != "" {
+			e.Footer = &discordEmbedFooter{
+				Text:    a.Footer,
+				IconURL: a.FooterIcon,
+			}
+		}
+		if a.ThumbURL != "" {
+			e.Thumbnail = &discordEmbedThumbnail{
+				URL: a.ThumbURL,
+			}
+		}
+		dm.Embeds = append(dm.Embeds, e)
+	}
+	return dm
+}
+
+// Post sends the provided message to the webhook, posting it in the channel.
+func (d *Discord) Post(msg *data.Message) error {
+	data, err := json.Marshal(toDiscord(msg))
+	if err != nil {
+		return err
+	}
+	if d.dry {
+		return dryRun("Discord", data)
+	}
+	if d.verbose {
+		logging.Verbosef(nil, "Posting Discord message: %s", data)
+	}
+	_, err = postJSON(d.client, d.webhook, nil, data, d.verbose)
+	return err
+}
@@ -0,0 +1,34 @@
+package processor
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/hb9tf/wireslacker/data"
+)
+
+const (
+	// ExportJSONL exports each log as a single line of JSON (JSON Lines).
+	ExportJSONL = "jsonl"
+)
+
+// NewExporter creates a new Exporter writing to w.
+func NewExporter(w io.Writer) *Exporter {
+	return &Exporter{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// Exporter writes parsed logs as JSON Lines, e.g. to verify the parsing or for archival.
+type Exporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// Export writes the log as a single line of JSON.
+func (e *Exporter) Export(evtLog *data.Log) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(evtLog)
+}
@@ -0,0 +1,283 @@
+package processor
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/logging"
+)
+
+const (
+	// MQTT 3.1.1 control packet types (already shifted into the upper nibble).
+	mqttConnect = 0x10
+	mqttConnack = 0x20
+	mqttPublish = 0x30
+	mqttPingreq = 0xc0
+
+	mqttProtocolLevel = 4
+	mqttFlagUsername  = 0x80
+	mqttFlagPassword  = 0x40
+	mqttFlagClean     = 0x02
+
+	// mqttKeepAlive is the keep alive interval announced to the broker.
+	mqttKeepAlive = time.Duration(60 * time.Second)
+	// mqttDialTimeout defines how long to wait for the broker to accept the connection.
+	mqttDialTimeout = time.Duration(10 * time.Second)
+	// mqttQueueSize is the number of messages buffered while the broker is unreachable.
+	mqttQueueSize = 100
+	// mqttMaxBackoff caps the time to wait between two connection attempts.
+	mqttMaxBackoff = time.Duration(1 * time.Minute)
+)
+
+var (
+	// mqttTopicEscaper replaces the characters which have a special meaning in MQTT topics.
+	mqttTopicEscaper = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+)
+
+// mqttMessage is a message waiting to be published.
+type mqttMessage struct {
+	topic   string
+	payload []byte
+}
+
+// NewMQTT creates a new MQTT notifier publishing to the broker (e.g. tcp://host:1883 or
+// ssl://host:8883). Messages are published as JSON to <topicPrefix>/<log ID>/event.
+// The connection to an ssl:// broker uses tlsConfig, the default configuration (strict
+// verification) if nil. The connection is established (and re-established) in the background.
+func NewMQTT(broker, topicPrefix, username, password string, tlsConfig *tls.Config, dry bool, verbose bool) (*MQTT, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse MQTT broker %q: %v", broker, err)
+	}
+	var useTLS bool
+	switch u.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		useTLS = true
+	default:
+		return nil, fmt.Errorf("unsupported MQTT broker scheme %q, use tcp:// or ssl://", u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "1883"
+		if useTLS {
+			port = "8883"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	hostname, _ := os.Hostname()
+	m := &MQTT{
+		addr,
+		useTLS,
+		tlsConfig,
+		strings.TrimSuffix(topicPrefix, "/"),
+		username,
+		password,
+		fmt.Sprintf("wireslacker-%s-%d", hostname, os.Getpid()),
+		make(chan *mqttMessage, mqttQueueSize),
+		dry,
+		verbose,
+	}
+	if !dry {
+		go m.run()
+	}
+	return m, nil
+}
+
+// MQTT implements the Notifier interface and publishes messages to an MQTT broker.
+type MQTT struct {
+	addr        string
+	useTLS      bool
+	tlsConfig   *tls.Config
+	topicPrefix string
+	username    string
+	password    string
+	clientID    string
+	queue       chan *mqttMessage
+	dry         bool
+	verbose     bool
+}
+
+// Post queues the provided message to be published. It never blocks: if the broker has been
+// unreachable for too long and the queue is full, an error is returned instead.
+func (m *MQTT) Post(msg *data.Message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	id := msg.LogID
+	if id == "" {
+		id = "unknown"
+	}
+	topic := fmt.Sprintf("%s/%s/event", m.topicPrefix, mqttTopicEscaper.Replace(id))
+	if m.dry {
+		return dryRun("MQTT ("+topic+")", payload)
+	}
+	select {
+	case m.queue <- &mqttMessage{topic, payload}:
+		return nil
+	default:
+		return errors.New("MQTT queue is full, broker unreachable?")
+	}
+}
+
+// run connects to the broker and publishes all queued messages, reconnecting with
+// exponential backoff whenever the connection is lost.
+func (m *MQTT) run() {
+	backoff := time.Second
+	var pending *mqttMessage
+	for {
+		conn, err := m.connect()
+		if err != nil {
+			logging.Errorf(logging.Fields{"broker": m.addr, "error": err}, "Unable to connect to MQTT broker %q (retrying in %s): %v", m.addr, backoff, err)
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > mqttMaxBackoff {
+				backoff = mqttMaxBackoff
+			}
+			continue
+		}
+		backoff = time.Second
+		if m.verbose {
+			logging.Verbosef(logging.Fields{"broker": m.addr}, "Connected to MQTT broker %q", m.addr)
+		}
+		pending, err = m.serve(conn, pending)
+		conn.Close()
+		logging.Errorf(logging.Fields{"broker": m.addr, "error": err}, "Lost connection to MQTT broker %q (reconnecting): %v", m.addr, err)
+	}
+}
+
+// serve publishes queued messages on the connection until it fails. It returns the message
+// which could not be published (if any) to be retried on the next connection.
+func (m *MQTT) serve(conn net.Conn, pending *mqttMessage) (*mqttMessage, error) {
+	// Drain everything the broker sends (e.g. PINGRESP) to notice a lost connection.
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(ioutil.Discard, conn)
+		if err == nil {
+			err = io.EOF
+		}
+		readErr <- err
+	}()
+
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	for {
+		if pending != nil {
+			if err := m.write(conn, mqttPublishPacket(pending)); err != nil {
+				return pending, err
+			}
+			if m.verbose {
+				logging.Verbosef(logging.Fields{"topic": pending.topic}, "Published MQTT message to %q", pending.topic)
+			}
+			pending = nil
+		}
+		select {
+		case pending = <-m.queue:
+		case <-ping.C:
+			if err := m.write(conn, []byte{mqttPingreq, 0}); err != nil {
+				return nil, err
+			}
+		case err := <-readErr:
+			return nil, err
+		}
+	}
+}
+
+func (m *MQTT) write(conn net.Conn, packet []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(mqttDialTimeout))
+	_, err := conn.Write(packet)
+	return err
+}
+
+// connect opens a connection to the broker and does the MQTT handshake.
+func (m *MQTT) connect() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: mqttDialTimeout}
+	var conn net.Conn
+	var err error
+	if m.useTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", m.addr, m.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", m.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.write(conn, m.connectPacket()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(mqttDialTimeout))
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no CONNACK from broker: %v", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if ack[0] != mqttConnack || ack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("connection refused by broker (return code %d)", ack[3])
+	}
+	return conn, nil
+}
+
+// connectPacket renders the CONNECT packet.
+func (m *MQTT) connectPacket() []byte {
+	flags := byte(mqttFlagClean)
+	if m.username != "" {
+		flags |= mqttFlagUsername
+		if m.password != "" {
+			flags |= mqttFlagPassword
+		}
+	}
+	body := mqttString("MQTT")
+	body = append(body, mqttProtocolLevel, flags, byte(mqttKeepAlive/time.Second>>8), byte(mqttKeepAlive/time.Second))
+	body = append(body, mqttString(m.clientID)...)
+	if m.username != "" {
+		body = append(body, mqttString(m.username)...)
+		if m.password != "" {
+			body = append(body, mqttString(m.password)...)
+		}
+	}
+	return mqttPacket(mqttConnect, body)
+}
+
+// mqttPublishPacket renders a QoS 0 PUBLISH packet.
+func mqttPublishPacket(msg *mqttMessage) []byte {
+	return mqttPacket(mqttPublish, append(mqttString(msg.topic), msg.payload...))
+}
+
+// mqttPacket prepends the fixed header (type and remaining length) to the body.
+func mqttPacket(typ byte, body []byte) []byte {
+	packet := []byte{typ}
+	l := len(body)
+	for {
+		b := byte(l % 128)
+		l /= 128
+		if l > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if l == 0 {
+			break
+		}
+	}
+	return append(packet, body...)
+}
+
+// mqttString encodes s as a length prefixed UTF-8 string.
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
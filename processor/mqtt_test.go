@@ -0,0 +1,74 @@
+package processor
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// serveMQTT accepts connections on l and acknowledges the CONNECT packet of each.
+func serveMQTT(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			// The CONNECT packets of the tests are shorter than 128 bytes, so the remaining
+			// length is a single byte.
+			header := make([]byte, 2)
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+				return
+			}
+			conn.Write([]byte{mqttConnack, 2, 0, 0})
+			io.Copy(io.Discard, conn)
+		}(conn)
+	}
+}
+
+// TestMQTTConnectTLS ensures the connection to an ssl:// broker is verified with the TLS
+// configuration provided, e.g. trusting the CA of a self-signed certificate.
+func TestMQTTConnectTLS(t *testing.T) {
+	// The test server provides a self-signed certificate for 127.0.0.1.
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveMQTT(l)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	tests := []struct {
+		desc      string
+		tlsConfig *tls.Config
+		wantErr   bool
+	}{
+		{"default", nil, true},
+		{"trusted CA", &tls.Config{RootCAs: pool}, false},
+		{"insecure", &tls.Config{InsecureSkipVerify: true}, false},
+	}
+	for _, tt := range tests {
+		m, err := NewMQTT("ssl://"+l.Addr().String(), "wireslacker", "", "", tt.tlsConfig, true, false)
+		if err != nil {
+			t.Fatalf("NewMQTT() failed: %v", err)
+		}
+		conn, err := m.connect()
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Errorf("connect(%s) error = %v, want error %t", tt.desc, err, tt.wantErr)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
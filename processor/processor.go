@@ -2,29 +2,82 @@ package processor
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	"hash/fnv"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/logging"
+	"github.com/hb9tf/wireslacker/metrics"
+	"github.com/hb9tf/wireslacker/reader"
 	"github.com/hb9tf/wireslacker/resolver"
 )
 
 const (
+	// WebhookSignatureHeader is the header of the signature of the posts to the webhook, see
+	// Slacker.SetWebhookAuth.
+	WebhookSignatureHeader = "X-Wireslacker-Signature-256"
+
 	httpPOST        = "POST"
 	httpContentType = "Content-Type"
 	httpJSON        = "application/json"
 
-	slackColorGood = "good"
+	// slackPostMessageURL is the Slack Web API endpoint to post messages with a bot token.
+	slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+	slackColorGood    = "good"
+	slackColorWarning = "warning"
+
+	// maxBackfillEvents is the maximum number of events posted for a log when backfilling (see
+	// Config.Since) so a busy log does not flood the channel on startup. The most recent ones win.
+	maxBackfillEvents = 20
+
+	// maxBatchSize is the maximum number of events posted in a single message when batching.
+	// Discord does not accept more than 10 embeds per message.
+	maxBatchSize = 10
+
+	// postQueueSize is the number of logs queued for each post worker (see Config.PostWorkers)
+	// before Run waits for them to be processed.
+	postQueueSize = 16
+
+	// postMaxAttempts is how many times a post is attempted before giving up.
+	postMaxAttempts = 5
+	// postInitialBackoff is the time to wait before the first retry, doubled on each further retry.
+	postInitialBackoff = time.Duration(1 * time.Second)
+	// postMaxBackoff caps the time to wait between two attempts.
+	postMaxBackoff = time.Duration(1 * time.Minute)
 )
 
 var (
+	eventsParsedTotal   = metrics.NewCounter("events_parsed_total", "Number of events parsed per log.", "log")
+	eventsFilteredTotal = metrics.NewCounter("events_filtered_total", "Number of events filtered per log.", "log")
+	postsAttemptedTotal = metrics.NewCounter("posts_attempted_total", "Number of attempted posts.")
+	postsSucceededTotal = metrics.NewCounter("posts_succeeded_total", "Number of successful posts.")
+	postsFailedTotal    = metrics.NewCounter("posts_failed_total", "Number of failed posts.")
+
+	// now returns the current time. Tests replace it to control the time based logic (e.g. which
+	// events are new) deterministically.
+	now = time.Now
+
+	// mapLinkTemplate is the URL template of the map linked in enriched messages (see mapURL).
+	mapLinkTemplate = "https://www.google.com/maps?q={lat},{lon}"
+
+	// httpTransport is the transport used by the notifiers to post, the default one if nil.
+	httpTransport http.RoundTripper
+
 	// timePostFormat is the date/time format presented in the Slack post.
 	timePostFormat = "2006-01-02 15:04:05"
 
@@ -35,178 +88,1142 @@ var (
 		"Browser connected from", // each poll creates such an entry, ignore them
 	}
 
-	// Mixed node and room RE
-	callStartRE   = regexp.MustCompile("Call Start No.([0-9]+)")
+	// Mixed node and room RE, used to extract the details of an event of the matching category.
+	// The callsign of the caller following the number is optional (e.g. "Call Start No.12345 HB9TF").
+	callStartRE   = regexp.MustCompile("Call Start No.([0-9]+)(?:[\\s(:]+([A-Za-z0-9]+(?:[/-][A-Za-z0-9]+)*))?")
 	connectedToRE = regexp.MustCompile("Connected to (.+)\\(([0-9]+)\\)\\.")
+	disconnectRE  = regexp.MustCompile("Disconnect(?:ed)?(?: from)? (.+)\\(([0-9]+)\\)")
+	// The number is the DTMF ID of a node in node logs and of a room in room logs.
+	inCallRE = regexp.MustCompile("In-Call from No.([0-9]+)")
 	// Node only RE
-	nodeInCallRE = regexp.MustCompile("In-Call from No.([0-9]+)")
-	nodeInRE     = regexp.MustCompile("(.+)\\(([0-9]+)\\) IN\\.")
-	nodeOutRE    = regexp.MustCompile("(.+)\\(([0-9]+)\\) OUT\\.")
+	nodeInRE  = regexp.MustCompile("(.+)\\(([0-9]+)\\) IN\\.")
+	nodeOutRE = regexp.MustCompile("(.+)\\(([0-9]+)\\) OUT\\.")
+
+	// enrichableCategories are the categories of the events referring to a node or room which
+	// can be enriched using the Yaesu lists.
+	enrichableCategories = map[data.Category]bool{
+		data.CategoryCallStart:    true,
+		data.CategoryInCall:       true,
+		data.CategoryConnected:    true,
+		data.CategoryDisconnected: true,
+		data.CategoryRoomIn:       true,
+		data.CategoryRoomOut:      true,
+	}
+	// notReadyLogged is set once it has been logged that events are not enriched as the Yaesu
+	// lists are not loaded yet.
+	notReadyLogged atomic.Bool
 )
 
-// NewSlacker creates a new Slacker for the provided webhook.
-func NewSlacker(webhook string, dry bool, verbose bool) *Slacker {
+// Notifier is an interface to post messages to a chat service.
+type Notifier interface {
+	// Post sends the provided message.
+	Post(msg *data.Message) error
+}
+
+// ThreadNotifier is implemented by notifiers which support threading messages.
+type ThreadNotifier interface {
+	Notifier
+	// PostThreaded sends the provided message as a reply in the thread identified by threadID,
+	// or as a new message if threadID is empty. It returns the thread ID of the posted message
+	// which may be empty if the backend does not provide one.
+	PostThreaded(msg *data.Message, threadID string) (string, error)
+}
+
+// Enricher adds information to the message of an event before it is posted, e.g. from a local
+// database. It returns the (possibly replaced) message, which must not be nil.
+type Enricher interface {
+	Enrich(evtLog *data.Log, evt *data.Event, msg *data.Message) *data.Message
+}
+
+// EnricherFunc is a function implementing the Enricher interface.
+type EnricherFunc func(evtLog *data.Log, evt *data.Event, msg *data.Message) *data.Message
+
+// Enrich calls f.
+func (f EnricherFunc) Enrich(evtLog *data.Log, evt *data.Event, msg *data.Message) *data.Message {
+	return f(evtLog, evt, msg)
+}
+
+// ResolverEnricher returns the default Enricher which adds the information about nodes and rooms
+// from the Yaesu lists (see resolver) using the settings of cfg. Use it to combine the default
+// enrichment with custom Enrichers (see Config.Enrichers).
+func ResolverEnricher(cfg Config, verbose bool) Enricher {
+	return EnricherFunc(func(evtLog *data.Log, evt *data.Event, msg *data.Message) *data.Message {
+		return enrich(evtLog, evt, msg, cfg, verbose)
+	})
+}
+
+// SetProxy changes the proxy the notifiers post through. The proxy configured in the environment
+// (HTTP_PROXY, HTTPS_PROXY and NO_PROXY) is used if nil. It only applies to the notifiers created
+// afterwards.
+func SetProxy(proxy *url.URL) {
+	if proxy == nil {
+		httpTransport = nil
+		return
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyURL(proxy)
+	httpTransport = t
+}
+
+// NewSlacker creates a new Slacker for the provided webhook. Posts are throttled by the limiter
+// unless it is nil.
+func NewSlacker(webhook string, limiter *RateLimiter, dry bool, verbose bool) *Slacker {
+	return &Slacker{
+		webhook: webhook,
+		client:  &http.Client{Transport: httpTransport},
+		limiter: limiter,
+		dry:     dry,
+		verbose: verbose,
+	}
+}
+
+// NewSlackBot creates a new Slacker which posts to the provided channel using the Slack Web API
+// and a bot token instead of a webhook. Unlike webhooks, this allows threading messages.
+func NewSlackBot(token, channel string, limiter *RateLimiter, dry bool, verbose bool) *Slacker {
 	return &Slacker{
-		webhook,
-		&http.Client{},
-		dry,
-		verbose,
+		webhook: slackPostMessageURL,
+		token:   token,
+		channel: channel,
+		client:  &http.Client{Transport: httpTransport},
+		limiter: limiter,
+		dry:     dry,
+		verbose: verbose,
 	}
 }
 
 // Slacker is a super simple Slack bot which allows to post messages using a webhook.
+// It implements the Notifier and ThreadNotifier interfaces.
 type Slacker struct {
 	webhook string
+	token   string
+	channel string
 	client  *http.Client
+	limiter *RateLimiter
 	dry     bool
 	verbose bool
+
+	// username, iconEmoji and iconURL are posted with messages which do not set their own.
+	username  string
+	iconEmoji string
+	iconURL   string
+
+	// header and signingKey authenticate the posts to the webhook (see SetWebhookAuth).
+	header     http.Header
+	signingKey []byte
+}
+
+// SetIdentity sets the name and icon (an emoji like :radio: or the URL of an image) the messages
+// are posted with instead of the defaults of the webhook or bot. Messages which set their own
+// are posted with those. Empty values keep the defaults.
+func (s *Slacker) SetIdentity(username, iconEmoji, iconURL string) {
+	s.username = username
+	s.iconEmoji = iconEmoji
+	s.iconURL = iconURL
+}
+
+// SetWebhookAuth sets additional headers (e.g. a shared secret) sent with each post to the webhook
+// and the key the posted body is signed with, for custom receivers which authenticate the posts.
+// If the key is not empty, the hex encoded HMAC-SHA256 of the body is sent in the
+// WebhookSignatureHeader as "sha256=<signature>". Neither is sent when posting using a bot token.
+func (s *Slacker) SetWebhookAuth(header http.Header, signingKey string) {
+	s.header = header
+	s.signingKey = []byte(signingKey)
+}
+
+// slackAPIMessage is the payload of a message posted using the Slack Web API.
+type slackAPIMessage struct {
+	Channel string `json:"channel"`
+	*data.Message
+}
+
+// slackAPIResponse is the response of the Slack Web API to a posted message.
+type slackAPIResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+	Ts    string `json:"ts"`
 }
 
 // Post sends the provided message to the webhook, posting it in the channel.
 func (s *Slacker) Post(msg *data.Message) error {
-	data, err := json.Marshal(msg)
+	_, err := s.PostThreaded(msg, "")
+	return err
+}
+
+// PostThreaded sends the provided message as a reply in the thread identified by threadID, or as a
+// new message if threadID is empty. It returns the thread ID of the posted message which is always
+// empty when posting using a webhook as Slack does not return it.
+func (s *Slacker) PostThreaded(msg *data.Message, threadID string) (string, error) {
+	m := *msg
+	m.ThreadTS = threadID
+	if m.Username == "" {
+		m.Username = s.username
+	}
+	if m.IconEmoji == "" && m.IconURL == "" {
+		m.IconEmoji, m.IconURL = s.iconEmoji, s.iconURL
+	}
+	var payload interface{} = &m
+	header := http.Header{}
+	if s.token != "" {
+		channel := s.channel
+		if m.Channel != "" {
+			channel = m.Channel
+		}
+		payload = &slackAPIMessage{channel, &m}
+		header.Set("Authorization", "Bearer "+s.token)
+	}
+	data, err := json.Marshal(payload)
 	if err != nil {
+		return "", err
+	}
+	if s.token == "" {
+		for name, values := range s.header {
+			header[name] = values
+		}
+		if len(s.signingKey) > 0 {
+			mac := hmac.New(sha256.New, s.signingKey)
+			mac.Write(data)
+			header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+	}
+	if s.dry {
+		return "", dryRun("Slack", data)
+	}
+	if wait := s.limiter.Wait(); wait > 0 && s.verbose {
+		logging.Verbosef(logging.Fields{"wait": wait.String()}, "Throttled Slack post for %s", wait)
+	}
+	if s.verbose {
+		logging.Verbosef(nil, "Posting Slack message: %s", data)
+	}
+	body, err := postJSON(s.client, s.webhook, header, data, s.verbose)
+	if err != nil || s.token == "" {
+		return "", err
+	}
+	resp := &slackAPIResponse{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return "", fmt.Errorf("unable to parse Slack response: %v", err)
+	}
+	if !resp.OK {
+		return "", fmt.Errorf("slack responded with error: %s", resp.Error)
+	}
+	if threadID != "" {
+		return threadID, nil
+	}
+	return resp.Ts, nil
+}
+
+// dryRun logs the pretty-printed JSON encoded data instead of posting it to the backend.
+func dryRun(backend string, data []byte) error {
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
 		return err
 	}
-	req, err := http.NewRequest(httpPOST, s.webhook, bytes.NewBuffer(data))
+	logging.Infof(logging.Fields{"backend": backend, "payload": json.RawMessage(data)}, "Dry run, not posting %s message:\n%s", backend, out.String())
+	return nil
+}
+
+// postJSON posts the JSON encoded data to the webhook, adding the provided headers, and returns
+// the response body. Failures which may be temporary (network errors, 429 and 5xx responses)
+// are retried with exponential backoff, respecting the Retry-After header if provided.
+func postJSON(client *http.Client, webhook string, header http.Header, data []byte, verbose bool) ([]byte, error) {
+	backoff := postInitialBackoff
+	for attempt := 1; ; attempt++ {
+		body, retryAfter, err := postJSONOnce(client, webhook, header, data)
+		if err == nil {
+			return body, nil
+		}
+		if retryAfter < 0 || attempt == postMaxAttempts {
+			return nil, err
+		}
+		if retryAfter == 0 {
+			retryAfter = backoff
+			backoff *= 2
+		}
+		if retryAfter > postMaxBackoff {
+			retryAfter = postMaxBackoff
+		}
+		if verbose {
+			logging.Verbosef(logging.Fields{"attempt": attempt, "error": err}, "Post attempt %d failed, retrying in %s: %v", attempt, retryAfter, err)
+		}
+		time.Sleep(retryAfter)
+	}
+}
+
+// postJSONOnce does a single attempt to post the JSON encoded data to the webhook and returns the
+// response body. If the post failed, it also returns how long to wait before retrying: 0 if
+// unknown and negative if the post should not be retried at all.
+func postJSONOnce(client *http.Client, webhook string, header http.Header, data []byte) ([]byte, time.Duration, error) {
+	req, err := http.NewRequest(httpPOST, webhook, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, -1, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
 	req.Header.Set(httpContentType, httpJSON)
-	if s.verbose {
-		log.Printf("V: Posting Slack message: %v", req)
+	resp, err := client.Do(req)
+	if err != nil {
+		// Webhook URLs (and bot tokens in them) are secrets, keep them out of the logs.
+		if uerr, ok := err.(*url.Error); ok {
+			err = fmt.Errorf("%s webhook: %v", uerr.Op, uerr.Err)
+		}
+		return nil, 0, err
 	}
-	if s.dry {
-		return nil
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return body, 0, err
+	}
+
+	err = fmt.Errorf("webhook responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		if secs, perr := strconv.Atoi(resp.Header.Get("Retry-After")); perr == nil && secs > 0 {
+			return nil, time.Duration(secs) * time.Second, err
+		}
+		return nil, 0, err
+	case resp.StatusCode >= 500:
+		return nil, 0, err
+	default:
+		return nil, -1, err
 	}
-	_, err = s.client.Do(req)
-	return err
 }
 
-// filter is a simple message filter which decides whether to drop a provided event.
-func filter(evt *data.Event, notBefore time.Time) bool {
+// filter is a simple message filter which decides whether to drop a provided event of evtLog.
+func filter(evtLog *data.Log, evt *data.Event, notBefore time.Time, cfg Config) bool {
 	// Filter all events which are older than notBefore (avoid posting the same thing twice).
-	if !evt.Ts.After(notBefore) {
+	// This includes events without a valid timestamp as they would be posted on every poll.
+	if evt.TsInvalid || !evt.Ts.After(notBefore) {
 		return true
 	}
 	// Filter all events containing any of the filter strings.
-	for _, fm := range filterMsg {
+	if !cfg.NoDefaultFilters {
+		for _, fm := range filterMsg {
+			if strings.Contains(evt.Msg, fm) {
+				return true
+			}
+		}
+	}
+	for _, fm := range cfg.Filters {
 		if strings.Contains(evt.Msg, fm) {
 			return true
 		}
 	}
+	// Filter all events matching any of the filter regexps.
+	for _, re := range cfg.FilterRegexps {
+		if re.MatchString(evt.Msg) {
+			return true
+		}
+	}
+	// Filter by category: excludes win over includes.
+	if len(cfg.IncludeCategories) > 0 || len(cfg.ExcludeCategories) > 0 {
+		c := string(evt.Category)
+		if contains(cfg.ExcludeCategories, c) {
+			return true
+		}
+		if len(cfg.IncludeCategories) > 0 && !contains(cfg.IncludeCategories, c) {
+			return true
+		}
+	}
+	// Filter by the node the event refers to.
+	if (len(cfg.AllowNodes) > 0 || len(cfg.AllowLocations) > 0) && !allowed(evtLog, evt, cfg) {
+		return true
+	}
+	return false
+}
+
+// allowed returns true if the callsign, ID or DTMF ID of the node the event refers to is one of
+// cfg.AllowNodes or if the node is located in one of cfg.AllowLocations. Events whose node cannot
+// be resolved are allowed if cfg.AllowUnresolved is true.
+func allowed(evtLog *data.Log, evt *data.Event, cfg Config) bool {
+	nodes := findNodes(evtLog, evt)
+	if len(nodes) == 0 {
+		return cfg.AllowUnresolved
+	}
+	n := nodes[0]
+	for _, a := range cfg.AllowNodes {
+		if strings.EqualFold(a, n.Callsign) || strings.EqualFold(a, n.ID) || resolver.SameNumber(a, n.DTMFID) {
+			return true
+		}
+	}
+	for _, l := range cfg.AllowLocations {
+		country, state, _ := strings.Cut(l, "/")
+		if resolver.InLocation(n.Location, strings.TrimSpace(country), strings.TrimSpace(state)) {
+			return true
+		}
+	}
 	return false
 }
 
+// contains returns true if s is in list.
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate shortens s to at most max characters, ending in an ellipsis if it was truncated.
+// s is returned unchanged if max is not positive.
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if max <= 0 || len(r) <= max {
+		return s
+	}
+	return strings.TrimSpace(string(r[:max-1])) + "…"
+}
+
+// mapURL fills the coordinates (in decimal degrees) into the {lat} and {lon} placeholders of the
+// URL template.
+func mapURL(lat, lon float64, template string) string {
+	return strings.NewReplacer(
+		"{lat}", strconv.FormatFloat(lat, 'f', 6, 64),
+		"{lon}", strconv.FormatFloat(lon, 'f', 6, 64),
+	).Replace(template)
+}
+
+// nodeURL renders the URL template of the page of node n, replacing {id}, {dtmf} and {callsign}.
+func nodeURL(n *data.Node, template string) string {
+	return strings.NewReplacer(
+		"{id}", url.PathEscape(n.ID),
+		"{dtmf}", url.PathEscape(n.DTMFID),
+		"{callsign}", url.PathEscape(n.Callsign),
+	).Replace(template)
+}
+
 // enrich is a simple function to pass all events through and add more information if available.
-func enrich(evtLog *data.Log, evt *data.Event, msg *data.Message, verbose bool) *data.Message {
-	// Attempt to resolve some information about calling nodes.
-	var n *data.Node
-	if match := nodeInCallRE.FindStringSubmatch(evt.Msg); len(match) > 1 {
-		n = resolver.FindNode("", match[1], "")
-	} else if match := callStartRE.FindStringSubmatch(evt.Msg); len(match) > 1 {
-		n = resolver.FindNode(match[1], match[2], "")
-	} else if match := connectedToRE.FindStringSubmatch(evt.Msg); len(match) > 1 {
-		n = resolver.FindNode("", match[1], "")
-	} else if match := nodeInRE.FindStringSubmatch(evt.Msg); len(match) > 1 {
-		n = resolver.FindNode(match[1], match[2], "")
-	} else if match := nodeOutRE.FindStringSubmatch(evt.Msg); len(match) > 1 {
-		n = resolver.FindNode(match[1], match[2], "")
-	}
-	if n != nil {
-		loc := "n/a"
-		if n.Location != nil {
-			loc = fmt.Sprintf("%s, %s, %s", n.Location.City, n.Location.State, n.Location.Country)
-			if n.Location.Lat != "" && n.Location.Lon != "" {
-				loc = fmt.Sprintf("<https://www.google.com/maps/place/%s+%s|%s>", url.PathEscape(n.Location.Lat), url.PathEscape(n.Location.Lon), loc)
+// Depending on the category, the event most likely refers to a node or a room: the most specific
+// one is looked up first and the other one only if nothing matched, so neither clobbers the other.
+func enrich(evtLog *data.Log, evt *data.Event, msg *data.Message, cfg Config, verbose bool) *data.Message {
+	// A previous Enricher may have removed the attachment.
+	ensureAttachment(evtLog, evt, msg)
+	var enriched bool
+	switch evt.Category {
+	case data.CategoryConnected, data.CategoryDisconnected:
+		// Nodes usually connect to rooms.
+		enriched = enrichRoom(evtLog, evt, msg, cfg, verbose) || enrichNode(evtLog, evt, msg, cfg, verbose)
+	default:
+		enriched = enrichNode(evtLog, evt, msg, cfg, verbose) || enrichRoom(evtLog, evt, msg, cfg, verbose)
+	}
+
+	// Fall back to the details the node reports on its own log page.
+	if !enriched && (evtLog.Freq != "" || evtLog.Status != "") {
+		var fields []data.AttachmentField
+		if evtLog.Freq != "" {
+			fields = append(fields, data.AttachmentField{Title: "Node frequency", Value: evtLog.Freq, Short: true})
+		}
+		if evtLog.Status != "" {
+			fields = append(fields, data.AttachmentField{Title: "Node status", Value: evtLog.Status, Short: true})
+		}
+		msg.Attachments[0].Fields = fields
+		if verbose {
+			logging.Verbosef(logging.Fields{"log_id": evtLog.ID}, "Enriched message with the details of the log page: %v", msg)
+		}
+	}
+	// Tell "not resolved" apart from "not looked up" while the Yaesu lists are not loaded yet,
+	// e.g. until the first update after the start succeeded.
+	if !enriched && enrichableCategories[evt.Category] && !resolver.Ready() {
+		if msg.Attachments[0].Text == "" {
+			msg.Attachments[0].Text = "(node and room details not available, the Yaesu lists have not been loaded yet)"
+		}
+		if !notReadyLogged.Swap(true) {
+			logging.Infof(logging.Fields{"log_id": evtLog.ID}, "Not enriching events until the Yaesu lists are loaded")
+		} else if verbose {
+			logging.Verbosef(logging.Fields{"log_id": evtLog.ID}, "Not enriching message as the Yaesu lists are not loaded yet: %v", msg)
+		}
+	}
+
+	return msg
+}
+
+// findNodes returns the nodes the event of evtLog refers to, the best match first (see
+// resolver.FindNodes).
+func findNodes(evtLog *data.Log, evt *data.Event) []*data.Node {
+	var nodes []*data.Node
+	switch evt.Category {
+	case data.CategoryInCall:
+		if evtLog.IsRoom() {
+			break // refers to a room
+		}
+		if match := inCallRE.FindStringSubmatch(evt.Msg); len(match) > 1 {
+			nodes = resolver.FindNodes("", match[1], "")
+		}
+	case data.CategoryCallStart:
+		if match := callStartRE.FindStringSubmatch(evt.Msg); len(match) > 2 {
+			// The number may be the ID or the DTMF ID of the node.
+			nodes = resolver.FindNodes(match[1], match[1], "")
+			// Fall back to the callsign if the number is not in the list.
+			if len(nodes) == 0 && match[2] != "" {
+				nodes = resolver.FindNodes("", "", match[2])
 			}
 		}
-		text := []string{
-			fmt.Sprintf("%s (%s):", n.ID, n.Mode),
-			fmt.Sprintf("Location: %s", loc),
+	case data.CategoryConnected:
+		if match := connectedToRE.FindStringSubmatch(evt.Msg); len(match) > 2 {
+			nodes = resolver.FindNodes("", match[2], "")
 		}
-		if n.Freq != "" {
-			text = append(text, fmt.Sprintf("Frequency: %s (%s)", n.Freq, n.SQL))
+	case data.CategoryDisconnected:
+		if match := disconnectRE.FindStringSubmatch(evt.Msg); len(match) > 2 {
+			nodes = resolver.FindNodes("", match[2], "")
 		}
-		if n.Comment != "" {
-			text = append(text, fmt.Sprintf("Comment: %s", n.Comment))
+	case data.CategoryRoomIn:
+		if match := nodeInRE.FindStringSubmatch(evt.Msg); len(match) > 2 {
+			nodes = resolver.FindNodes(match[1], match[2], "")
 		}
-		msg.Attachments[0].Text = strings.Join(text, "\n")
-		msg.Attachments[0].Color = slackColorGood
-		if verbose {
-			log.Printf("V: Enriched message with node information: %v", msg)
+	case data.CategoryRoomOut:
+		if match := nodeOutRE.FindStringSubmatch(evt.Msg); len(match) > 2 {
+			nodes = resolver.FindNodes(match[1], match[2], "")
 		}
 	}
+	return nodes
+}
 
-	// Attempt to resolve some information about rooms.
-	var r *data.Room
-	if match := callStartRE.FindStringSubmatch(evt.Msg); len(match) > 1 {
-		r = resolver.FindRoom(match[1], match[2], "")
-	} else if match := connectedToRE.FindStringSubmatch(evt.Msg); len(match) > 1 {
-		r = resolver.FindRoom("", match[1], "")
+// enrichNode adds information about the node the event refers to if it can be resolved.
+// It returns true if the message was enriched.
+func enrichNode(evtLog *data.Log, evt *data.Event, msg *data.Message, cfg Config, verbose bool) bool {
+	nodes := findNodes(evtLog, evt)
+	if len(nodes) == 0 {
+		return false
 	}
-	if r != nil {
-		loc := "n/a"
-		if r.Location != nil {
-			loc = fmt.Sprintf("%s, %s, %s", r.Location.City, r.Location.State, r.Location.Country)
+	n := nodes[0]
+	loc := "n/a"
+	if n.Location != nil {
+		loc = fmt.Sprintf("%s, %s, %s", n.Location.City, n.Location.State, n.Location.Country)
+		if n.Location.Lat != 0 || n.Location.Lon != 0 {
+			loc = fmt.Sprintf("<%s|%s>", mapURL(n.Location.Lat, n.Location.Lon, mapLinkTemplate), loc)
+			if cfg.MapThumbURL != "" {
+				msg.Attachments[0].ThumbURL = mapURL(n.Location.Lat, n.Location.Lon, cfg.MapThumbURL)
+			}
 		}
-		text := []string{
-			fmt.Sprintf("%s: %s", r.ID, r.Name),
-			fmt.Sprintf("Location: %s", loc),
+	}
+	// Structured information is rendered as fields in a grid, the rest as text.
+	fields := []data.AttachmentField{}
+	if n.Callsign != "" {
+		fields = append(fields, data.AttachmentField{Title: "Callsign", Value: n.Callsign, Short: true})
+	}
+	if n.Mode != "" {
+		fields = append(fields, data.AttachmentField{Title: "Mode", Value: n.Mode, Short: true})
+	}
+	if n.Freq != "" {
+		fields = append(fields, data.AttachmentField{Title: "Frequency", Value: fmt.Sprintf("%s (%s)", n.Freq, n.SQL), Short: true})
+	}
+	fields = append(fields, data.AttachmentField{Title: "Location", Value: loc, Short: true})
+	// The title identifies the node, linking to its page if configured.
+	msg.Attachments[0].Title = n.ID
+	if n.Callsign != "" {
+		msg.Attachments[0].Title = fmt.Sprintf("%s (%s)", n.Callsign, n.ID)
+	}
+	if cfg.NodeURL != "" {
+		msg.Attachments[0].TitleLink = nodeURL(n, cfg.NodeURL)
+	}
+	var text []string
+	if n.Comment != "" {
+		text = append(text, fmt.Sprintf("Comment: %s", n.Comment))
+	}
+	if len(nodes) > 1 {
+		text = append(text, fmt.Sprintf("(%d candidate nodes matched, showing the first)", len(nodes)))
+	}
+	if age, ok := resolver.NodesAge(); ok && cfg.StaleAfter > 0 && age > cfg.StaleAfter {
+		text = append(text, fmt.Sprintf("(data may be stale, last updated %s ago)", age.Round(time.Minute)))
+	}
+	msg.Attachments[0].Text = truncate(strings.Join(text, "\n"), cfg.MaxTextLength)
+	msg.Attachments[0].Fields = fields
+	msg.Attachments[0].Color = slackColorGood
+	if verbose {
+		logging.Verbosef(logging.Fields{"log_id": evtLog.ID}, "Enriched message with node information: %v", msg)
+	}
+	return true
+}
+
+// enrichRoom adds information about the room the event refers to if it can be resolved.
+// It returns true if the message was enriched.
+func enrichRoom(evtLog *data.Log, evt *data.Event, msg *data.Message, cfg Config, verbose bool) bool {
+	var rooms []*data.Room
+	switch evt.Category {
+	case data.CategoryInCall:
+		if match := inCallRE.FindStringSubmatch(evt.Msg); len(match) > 1 && evtLog.IsRoom() {
+			rooms = resolver.FindRooms("", match[1], "")
 		}
-		if r.Comment != "" {
-			text = append(text, fmt.Sprintf("Comment: %s", r.Comment))
+	case data.CategoryCallStart:
+		if match := callStartRE.FindStringSubmatch(evt.Msg); len(match) > 1 {
+			rooms = resolver.FindRooms(match[1], match[1], "")
 		}
-		msg.Attachments[0].Text = strings.Join(text, "\n")
-		msg.Attachments[0].Color = slackColorGood
-		if verbose {
-			log.Printf("V: Enriched message with room information: %v", msg)
+	case data.CategoryConnected:
+		if match := connectedToRE.FindStringSubmatch(evt.Msg); len(match) > 2 {
+			rooms = resolver.FindRooms(match[2], match[2], strings.TrimSpace(match[1]))
 		}
+	case data.CategoryDisconnected:
+		if match := disconnectRE.FindStringSubmatch(evt.Msg); len(match) > 2 {
+			rooms = resolver.FindRooms(match[2], match[2], strings.TrimSpace(match[1]))
+		}
+	}
+	if len(rooms) == 0 {
+		return false
+	}
+	r := rooms[0]
+	loc := "n/a"
+	if r.Location != nil {
+		loc = fmt.Sprintf("%s, %s, %s", r.Location.City, r.Location.State, r.Location.Country)
+	}
+	text := []string{
+		fmt.Sprintf("%s: %s", r.ID, r.Name),
+		fmt.Sprintf("Location: %s", loc),
+	}
+	// Act is the number of nodes currently connected to the room.
+	if act := strings.TrimSpace(r.Act); act != "" {
+		text = append(text, fmt.Sprintf("Activity: %s connected", act))
+	}
+	if r.Comment != "" {
+		text = append(text, fmt.Sprintf("Comment: %s", r.Comment))
+	}
+	if len(rooms) > 1 {
+		text = append(text, fmt.Sprintf("(%d candidate rooms matched, showing the first)", len(rooms)))
+	}
+	if age, ok := resolver.RoomsAge(); ok && cfg.StaleAfter > 0 && age > cfg.StaleAfter {
+		text = append(text, fmt.Sprintf("(data may be stale, last updated %s ago)", age.Round(time.Minute)))
+	}
+	msg.Attachments[0].Text = truncate(strings.Join(text, "\n"), cfg.MaxTextLength)
+	msg.Attachments[0].Fields = nil
+	msg.Attachments[0].Color = slackColorGood
+	if verbose {
+		logging.Verbosef(logging.Fields{"log_id": evtLog.ID}, "Enriched message with room information: %v", msg)
 	}
+	return true
+}
 
+// getSlackMsg returns the (enriched) message of the event of evtLog. The durations of the
+// connections ended by disconnected events (see connections.observe) are shown if known.
+func getSlackMsg(evtLog *data.Log, evt *data.Event, durations map[*data.Event]time.Duration, cfg Config, verbose bool) *data.Message {
+	msg := &data.Message{
+		LogID:       evtLog.ID,
+		Channel:     cfg.Channels[reader.Source(evtLog.Source)],
+		Attachments: []data.Attachment{eventAttachment(evtLog, evt)},
+	}
+	if cfg.Enrichers == nil {
+		msg = enrich(evtLog, evt, msg, cfg, verbose)
+	}
+	for _, e := range cfg.Enrichers {
+		msg = e.Enrich(evtLog, evt, msg)
+	}
+	// An Enricher may have removed the attachment.
+	ensureAttachment(evtLog, evt, msg)
+	if cfg.IncludeRaw && evt.Raw != "" {
+		a := &msg.Attachments[0]
+		raw := "```" + evt.Raw + "```"
+		if a.Text != "" {
+			raw = a.Text + "\n" + raw
+		}
+		a.Text = raw
+		if !contains(a.MarkdownIn, "text") {
+			a.MarkdownIn = append(a.MarkdownIn, "text")
+		}
+	}
+	// Make disconnects stand out from everything else.
+	if evt.Category == data.CategoryDisconnected {
+		if match := disconnectRE.FindStringSubmatch(evt.Msg); len(match) > 2 {
+			msg.Attachments[0].Pretext = fmt.Sprintf("%s: Disconnected from %s (%s)", evtLog.ID, strings.TrimSpace(match[1]), match[2])
+			if d, ok := durations[evt]; ok {
+				msg.Attachments[0].Pretext += fmt.Sprintf(" after %s", d.Round(time.Second))
+			}
+			msg.Attachments[0].Color = slackColorWarning
+		}
+	}
+	// Colors configured for the category override the defaults above.
+	if c, ok := cfg.Colors[evt.Category]; ok {
+		msg.Attachments[0].Color = c
+	}
 	return msg
 }
 
-func getSlackMsg(evtLog *data.Log, evt *data.Event, verbose bool) *data.Message {
-	msg := &data.Message{
-		Attachments: []data.Attachment{
-			{
-				Pretext: fmt.Sprintf(
-					"%s: %s",
-					evtLog.ID,
-					evt.Msg),
-				//Ts: json.Number(strconv.FormatInt(evt.Ts.Unix(), 10)),
-			},
-		},
-	}
-	return enrich(evtLog, evt, msg, verbose)
-}
-
-// Run iterates over all logs provided in the log channel and posts new messages using the Slacker provided.
-func Run(logChan chan *data.Log, slkr *Slacker, verbose bool) {
-	logCount := 0
-	notBefore := time.Now()
+// Config contains the optional settings of the processor. The zero value keeps the defaults.
+type Config struct {
+	// BatchWindow enables batching if positive: events of the same log which happened within
+	// this window of the first one are posted as a single message. Disabled by default.
+	BatchWindow time.Duration
+
+	// Filters are additional strings: events containing any of them are not posted.
+	Filters []string
+	// FilterRegexps are regexps: events matching any of them are not posted.
+	FilterRegexps []*regexp.Regexp
+	// NoDefaultFilters disables the built-in filters (see filterMsg) if true.
+	NoDefaultFilters bool
+	// IncludeCategories restricts the posted events to these categories if not empty.
+	IncludeCategories []string
+	// ExcludeCategories are the categories of events which are not posted.
+	ExcludeCategories []string
+
+	// Colors maps event categories to the color of their attachment (good, warning, danger or a
+	// hex color like #439fe0), overriding the default. An empty color is neutral.
+	Colors map[data.Category]string
+
+	// AllowNodes restricts the posted events to the ones referring to these nodes (callsign, ID
+	// or DTMF ID) if not empty. The node is resolved like for the enrichment.
+	AllowNodes []string
+	// AllowLocations restricts the posted events to the ones referring to nodes located in these
+	// countries (e.g. "Switzerland") or states of a country (e.g. "Switzerland/Bern") if not
+	// empty. Events allowed by AllowNodes are posted regardless of their location.
+	AllowLocations []string
+	// AllowUnresolved posts events whose node cannot be resolved even if AllowNodes or
+	// AllowLocations is set.
+	AllowUnresolved bool
+
+	// Thread posts all events of the same node or room (Log.ID) as replies to the first message
+	// posted for it since the start, if the notifier supports it (see ThreadNotifier).
+	Thread bool
+
+	// Routes maps a log source (see reader.Source) or ID to the notifiers to post its events to
+	// instead of the default ones.
+	Routes map[string][]Notifier
+
+	// Channels maps a log source (see reader.Source) to the slack channel to post its events to instead of the one
+	// of the webhook or bot (see data.Message.Channel).
+	Channels map[string]string
+
+	// StaleAfter is the age of the Yaesu active nodes and rooms lists after which enriched
+	// messages note that the information may be stale. Disabled if not positive.
+	StaleAfter time.Duration
+
+	// MapThumbURL is the URL template of a static map image shown as thumbnail of enriched
+	// messages, with {lat} and {lon} as placeholders for the coordinates. Disabled if empty.
+	MapThumbURL string
+
+	// IncludeRaw appends the raw log line of each event to the text of its attachment as a code
+	// block, e.g. to debug the parsing.
+	IncludeRaw bool
+
+	// NodeURL is the URL template of the page of a node linked from the title of enriched
+	// messages, with {id}, {dtmf} and {callsign} as placeholders. The title is not linked if empty.
+	NodeURL string
+
+	// MaxTextLength is the maximum number of characters of the text of enriched messages (e.g.
+	// with long comments of nodes or rooms), longer ones are truncated. Disabled if not positive.
+	MaxTextLength int
+
+	// DedupWindow enables deduplication if positive: an event with the same log ID, timestamp
+	// and message as one posted (from any source) within this window is not posted again. This
+	// also allows posting events sharing the timestamp of the last posted event of a source.
+	DedupWindow time.Duration
+
+	// PostWorkers is the number of workers processing and posting the logs of different nodes
+	// and rooms concurrently (see Run). The logs are processed one after the other if not above 1.
+	PostWorkers int
+
+	// ConnectionEvents posts an event whenever the node of a log connects to another node or
+	// room (see data.Log.ConnectedTo).
+	ConnectionEvents bool
+
+	// Since posts the events of a log source which has not been posted to before (see State) which
+	// happened up to this long before Run has been called, at most maxBackfillEvents of them.
+	Since time.Duration
+
+	// Backfill posts all events of a log source which has not been posted to before (see State)
+	// instead of only the ones which happened after Run has been called.
+	Backfill bool
+
+	// Enrichers are applied in order to the message of each event before it is posted. If nil,
+	// the default ResolverEnricher is used. To keep it in addition to custom ones, add it to
+	// the chain explicitly.
+	Enrichers []Enricher
+
+	// Recent records the most recent posted events if set.
+	Recent *Recent
+
+	// Exporter exports each log as received (before filtering) if set.
+	Exporter *Exporter
+
+	// Archive archives each new event which passed the filters if set, independently of the
+	// notifiers.
+	Archive *Archive
+}
+
+// batchEvents splits the (sorted) events into batches of events which happened within window
+// of the first event of the batch. If window is not positive, each event is its own batch.
+func batchEvents(events []*data.Event, window time.Duration) [][]*data.Event {
+	var batches [][]*data.Event
+	for _, evt := range events {
+		if window > 0 && len(batches) > 0 {
+			last := batches[len(batches)-1]
+			if len(last) < maxBatchSize && evt.Ts.Sub(last[0].Ts) <= window {
+				batches[len(batches)-1] = append(last, evt)
+				continue
+			}
+		}
+		batches = append(batches, []*data.Event{evt})
+	}
+	return batches
+}
+
+// eventAttachment returns the plain attachment of the event of evtLog, before any enrichment.
+func eventAttachment(evtLog *data.Log, evt *data.Event) data.Attachment {
+	return data.Attachment{
+		Pretext: fmt.Sprintf(
+			"%s: %s",
+			evtLog.ID,
+			evt.Msg),
+		Footer: footer(evtLog),
+		Ts:     json.Number(strconv.FormatInt(evt.Ts.Unix(), 10)),
+	}
+}
+
+// footer returns the provenance of the events of evtLog shown in the footer of their attachments:
+// where the log was read from and the Wires-X version, e.g. "via host/nodelog.html · WIRES-X
+// Ver.1.600". The query of URLs is left out as it may contain secrets (e.g. wipassword).
+func footer(evtLog *data.Log) string {
+	source := evtLog.Source
+	if u, err := url.Parse(source); err == nil && u.Host != "" {
+		source = u.Host + u.Path
+	}
+	parts := []string{"via " + source}
+	if evtLog.WiresVersion != "" {
+		parts = append(parts, evtLog.WiresVersion)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// ensureAttachment adds the plain attachment of the event (see eventAttachment) to msg if it has
+// none, so the first attachment can always be accessed.
+func ensureAttachment(evtLog *data.Log, evt *data.Event, msg *data.Message) {
+	if len(msg.Attachments) == 0 {
+		msg.Attachments = append(msg.Attachments, eventAttachment(evtLog, evt))
+	}
+}
+
+// getBatchMsg combines the messages of all events of a batch into a single message with the
+// attachments of each event. It also returns the main attachment of each event (the first one
+// of its message) as an Enricher may have added others.
+func getBatchMsg(evtLog *data.Log, batch []*data.Event, durations map[*data.Event]time.Duration, cfg Config, verbose bool) (*data.Message, []data.Attachment) {
+	msg := getSlackMsg(evtLog, batch[0], durations, cfg, verbose)
+	main := []data.Attachment{msg.Attachments[0]}
+	for _, evt := range batch[1:] {
+		m := getSlackMsg(evtLog, evt, durations, cfg, verbose)
+		msg.Attachments = append(msg.Attachments, m.Attachments...)
+		main = append(main, m.Attachments[0])
+	}
+	return msg, main
+}
+
+// threadIDs maps each notifier and thread key to the ID of the thread to reply to (see post). It
+// is safe for concurrent use.
+type threadIDs struct {
+	mu  sync.Mutex
+	ids map[Notifier]map[string]string
+}
+
+// get returns the ID of the thread of key on notifier n, empty if there is none yet.
+func (t *threadIDs) get(n Notifier, key string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ids[n][key]
+}
+
+// add records id as the thread of key on notifier n unless there already is one.
+func (t *threadIDs) add(n Notifier, key, id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ids[n] == nil {
+		t.ids[n] = map[string]string{}
+	}
+	if _, ok := t.ids[n][key]; !ok && id != "" {
+		t.ids[n][key] = id
+	}
+}
+
+// post sends msg using the notifier. If threading is enabled, the message is posted as a reply to
+// the first message posted with the same threadKey (tracked in threads).
+func post(notifier Notifier, msg *data.Message, threadKey string, threads *threadIDs, thread bool) error {
+	tn, ok := notifier.(ThreadNotifier)
+	if !thread || !ok {
+		return notifier.Post(msg)
+	}
+	threadID, err := tn.PostThreaded(msg, threads.get(notifier, threadKey))
+	if err != nil {
+		return err
+	}
+	threads.add(notifier, threadKey, threadID)
+	return nil
+}
+
+// Alert posts text as a plain message to all notifiers, e.g. to report that a target is
+// unreachable. Errors are logged only.
+func Alert(notifiers []Notifier, text string) {
+	msg := &data.Message{Text: text}
+	for i, err := range postAll(notifiers, msg, "", nil, false) {
+		if err != nil {
+			logging.Errorf(logging.Fields{"notifier": fmt.Sprintf("%T", notifiers[i]), "error": err}, "Error posting alert using %T: %v", notifiers[i], err)
+		}
+	}
+}
+
+// route returns the notifiers to post the events of evtLog to: the ones routed for its source
+// or (if none) its ID, falling back to the default ones.
+func route(evtLog *data.Log, defaults []Notifier, routes map[string][]Notifier) []Notifier {
+	if n, ok := routes[reader.Source(evtLog.Source)]; ok {
+		return n
+	}
+	if n, ok := routes[evtLog.ID]; ok {
+		return n
+	}
+	return defaults
+}
+
+// connectionChange tracks what the node of each log is connected to (see data.Log.ConnectedTo
+// and logKey) in connected. It returns an event describing the new connection if it changed
+// since the last log of the same node, nil otherwise. Nothing is returned for the first log of a
+// node as the previous connection is unknown, nor when the node disconnected as this is already
+// logged as an event by Wires-X.
+func connectionChange(evtLog *data.Log, connected map[string]string) *data.Event {
+	key := logKey(evtLog)
+	prev, known := connected[key]
+	connected[key] = evtLog.ConnectedTo
+	if !known || evtLog.ConnectedTo == prev || evtLog.ConnectedTo == "" {
+		return nil
+	}
+	msg := fmt.Sprintf("Connected to %s.", strings.TrimSpace(evtLog.ConnectedTo))
+	category := data.CategoryOther
+	if connectedToRE.MatchString(msg) {
+		category = data.CategoryConnected
+	}
+	return &data.Event{
+		Raw:      evtLog.ConnectedTo,
+		Ts:       now(),
+		Msg:      msg,
+		Category: category,
+	}
+}
+
+// postAll sends msg to all notifiers concurrently so a slow or failing notifier does not hold
+// back the others. It returns the error of each notifier (nil on success) in the same order.
+func postAll(notifiers []Notifier, msg *data.Message, threadKey string, threads *threadIDs, thread bool) []error {
+	errs := make([]error, len(notifiers))
+	var wg sync.WaitGroup
+	for i, n := range notifiers {
+		wg.Add(1)
+		go func(i int, n Notifier) {
+			defer wg.Done()
+			postsAttemptedTotal.Inc()
+			if errs[i] = post(n, msg, threadKey, threads, thread); errs[i] != nil {
+				postsFailedTotal.Inc()
+				return
+			}
+			postsSucceededTotal.Inc()
+		}(i, n)
+	}
+	wg.Wait()
+	return errs
+}
+
+// Run iterates over all logs provided in the log channel and posts new messages using the Notifiers provided.
+// Only events newer than the last posted event of the same log source (as recorded in state) are posted.
+// An event counts as posted once at least one of the notifiers accepted it.
+// If cfg.PostWorkers is above 1, the logs of different nodes or rooms are processed and posted
+// concurrently so a slow post does not hold back the others. The logs of the same node or room
+// (or of the same source if its ID is unknown) are still processed in order, whichever source
+// they are read from.
+// Run returns once the log channel has been closed and all logs have been processed.
+func Run(logChan chan *data.Log, notifiers []Notifier, state *State, cfg Config, verbose bool) {
+	start := now().Add(-cfg.Since)
+	if cfg.Backfill {
+		start = time.Time{}
+	}
+	r := &runner{
+		notifiers:  notifiers,
+		state:      state,
+		cfg:        cfg,
+		verbose:    verbose,
+		start:      start,
+		backfilled: map[string]bool{},
+		dd:         newDedup(cfg.DedupWindow),
+		archived:   newDedup(archiveWindow),
+		connected:  map[string]string{},
+		conns:      connections{},
+		threads:    &threadIDs{ids: map[Notifier]map[string]string{}},
+	}
+	// Each worker processes the logs of the nodes and rooms hashed to it, in the order received.
+	var workers []chan *data.Log
+	var wg sync.WaitGroup
+	if cfg.PostWorkers > 1 {
+		for i := 0; i < cfg.PostWorkers; i++ {
+			queue := make(chan *data.Log, postQueueSize)
+			workers = append(workers, queue)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for evtLog := range queue {
+					r.process(evtLog)
+				}
+			}()
+		}
+	}
 	for evtLog := range logChan {
-		logCount++
-		evtCount := 0
-		evtFltrCount := 0
 		sort.Sort(data.ByAge(evtLog.Events))
-		var lastTs time.Time
-		for _, evt := range evtLog.Events {
-			evtCount++
-			if filter(evt, notBefore) {
-				evtFltrCount++
-				continue
+		if cfg.Exporter != nil {
+			if err := cfg.Exporter.Export(evtLog); err != nil {
+				logging.Errorf(logging.Fields{"target": evtLog.Source, "error": err}, "Unable to export log: %v", err)
 			}
-			lastTs = evt.Ts
+		}
+		if len(workers) == 0 {
+			r.process(evtLog)
+			continue
+		}
+		// The logs of the same node are processed by the same worker, even if read from different
+		// sources (e.g. a mirror), so they are deduplicated and tracked in order.
+		key := evtLog.ID
+		if key == "" {
+			key = evtLog.Source
+		}
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		workers[h.Sum32()%uint32(len(workers))] <- evtLog
+	}
+	for _, queue := range workers {
+		close(queue)
+	}
+	wg.Wait()
+}
+
+// runner is the state of Run shared by the workers processing the logs.
+type runner struct {
+	notifiers []Notifier
+	state     *State
+	cfg       Config
+	verbose   bool
+	// start is the time before which events are not posted for logs without a state.
+	start time.Time
+
+	// mu guards the fields below. It is not held while posting.
+	mu       sync.Mutex
+	logCount int
+	// backfilled are the logs (see logKey) whose first log has been processed since the start.
+	backfilled map[string]bool
+	dd         *dedup
+	archived   *dedup // the events already added to cfg.Archive
+	connected  map[string]string
+	conns      connections
+	// threads is safe for concurrent use on its own.
+	threads *threadIDs
+}
 
-			log.Printf("New message from %s (%s): %v", evtLog.ID, evtLog.Type, evt)
-			if err := slkr.Post(getSlackMsg(evtLog, evt, verbose)); err != nil {
-				log.Printf("Error posting message to Slack: %v", err)
+// archive adds the events of evtLog which have not been archived yet to cfg.Archive with the
+// attachment they are posted as.
+func (r *runner) archive(evtLog *data.Log, events []*data.Event, durations map[*data.Event]time.Duration) {
+	r.mu.Lock()
+	var added []*data.Event
+	for _, evt := range events {
+		if !r.archived.duplicate(evtLog, evt) {
+			added = append(added, evt)
+		}
+	}
+	r.archived.add(evtLog, added)
+	r.mu.Unlock()
+	for _, evt := range added {
+		msg := getSlackMsg(evtLog, evt, durations, r.cfg, r.verbose)
+		if err := r.cfg.Archive.Add(evtLog, evt, msg.Attachments[0]); err != nil {
+			logging.Errorf(logging.Fields{"target": evtLog.Source, "error": err}, "Unable to archive event: %v", err)
+		}
+	}
+}
+
+// process posts the new events of evtLog (sorted by age) to its notifiers.
+func (r *runner) process(evtLog *data.Log) {
+	cfg, state, verbose := r.cfg, r.state, r.verbose
+	evtCount := 0
+	evtFltrCount := 0
+	logNotifiers := route(evtLog, r.notifiers, cfg.Routes)
+	// The state used to be kept under the source only (see logKey), which is used until the log
+	// is posted to.
+	key := logKey(evtLog)
+	notBefore := state.NotBefore(key, state.NotBefore(evtLog.Source, r.start))
+
+	r.mu.Lock()
+	r.logCount++
+	logCount := r.logCount
+	durations := r.conns.observe(evtLog)
+	if r.dd.covers(evtLog, notBefore) {
+		// The events posted with this timestamp are remembered, so the ones sharing it which
+		// have not been posted yet can be told apart and posted.
+		notBefore = notBefore.Add(-time.Nanosecond)
+	}
+	backfill := cfg.Since > 0 && !r.backfilled[key] && !state.Known(key) && !state.Known(evtLog.Source)
+	r.backfilled[key] = true
+	var events []*data.Event
+	for _, evt := range evtLog.Events {
+		evtCount++
+		if filter(evtLog, evt, notBefore, cfg) {
+			evtFltrCount++
+			continue
+		}
+		if r.dd.duplicate(evtLog, evt) {
+			evtFltrCount++
+			if verbose {
+				logging.Verbosef(logging.Fields{"target": evtLog.Source, "log_id": evtLog.ID, "event": evt.Msg}, "Dropping event already posted: %v", evt)
 			}
+			continue
 		}
-		if lastTs.After(notBefore) {
-			notBefore = lastTs
+		events = append(events, evt)
+	}
+	r.mu.Unlock()
+	if backfill && len(events) > maxBackfillEvents {
+		logging.Infof(logging.Fields{"target": evtLog.Source, "skipped_count": len(events) - maxBackfillEvents}, "Backfilling only the last %d events of %s, skipping %d", maxBackfillEvents, evtLog.Source, len(events)-maxBackfillEvents)
+		evtFltrCount += len(events) - maxBackfillEvents
+		events = events[len(events)-maxBackfillEvents:]
+	}
+	if cfg.Archive != nil {
+		r.archive(evtLog, events, durations)
+	}
+	// Nothing to post to when only exporting.
+	if len(logNotifiers) == 0 {
+		events = nil
+	}
+
+	for _, batch := range batchEvents(events, cfg.BatchWindow) {
+		for _, evt := range batch {
+			logging.Infof(logging.Fields{"target": evtLog.Source, "log_id": evtLog.ID, "log_type": evtLog.Type, "event": evt.Msg}, "New message from %s (%s): %v", evtLog.ID, evtLog.Type, evt)
 		}
-		if verbose {
-			log.Printf("V: Processed log #%d, total of %d events, filtered %d", logCount, evtCount, evtFltrCount)
+		msg, attachments := getBatchMsg(evtLog, batch, durations, cfg, verbose)
+		failed := 0
+		for i, err := range postAll(logNotifiers, msg, evtLog.ID, r.threads, cfg.Thread) {
+			if err != nil {
+				failed++
+				logging.Errorf(logging.Fields{"target": evtLog.Source, "notifier": fmt.Sprintf("%T", logNotifiers[i]), "error": err}, "Error posting message using %T: %v", logNotifiers[i], err)
+			}
+		}
+		if failed == len(logNotifiers) {
+			// Stop here without advancing past these events so they are retried with the next poll.
+			logging.Errorf(logging.Fields{"target": evtLog.Source}, "Unable to post message to any notifier (retrying with next poll)")
+			break
 		}
+		r.mu.Lock()
+		r.dd.add(evtLog, batch)
+		r.mu.Unlock()
+		if cfg.Recent != nil {
+			for i, evt := range batch {
+				cfg.Recent.Add(evtLog, evt, attachments[i])
+			}
+		}
+		resolver.Activity()
+		last := batch[len(batch)-1]
+		if err := state.Update(key, last.Ts); err != nil {
+			logging.Errorf(logging.Fields{"error": err}, "Unable to persist state: %v", err)
+		}
+	}
+	r.mu.Lock()
+	evt := connectionChange(evtLog, r.connected)
+	r.mu.Unlock()
+	if evt != nil && cfg.ConnectionEvents && len(logNotifiers) > 0 && !filter(evtLog, evt, time.Time{}, cfg) {
+		logging.Infof(logging.Fields{"target": evtLog.Source, "log_id": evtLog.ID, "event": evt.Msg}, "Connection of %s changed: %v", evtLog.ID, evt)
+		msg := getSlackMsg(evtLog, evt, nil, cfg, verbose)
+		for i, err := range postAll(logNotifiers, msg, evtLog.ID, r.threads, cfg.Thread) {
+			if err != nil {
+				logging.Errorf(logging.Fields{"target": evtLog.Source, "notifier": fmt.Sprintf("%T", logNotifiers[i]), "error": err}, "Error posting message using %T: %v", logNotifiers[i], err)
+			}
+		}
+		if cfg.Recent != nil {
+			cfg.Recent.Add(evtLog, evt, msg.Attachments[0])
+		}
+	}
+	eventsParsedTotal.Add(float64(evtCount), evtLog.Source)
+	eventsFilteredTotal.Add(float64(evtFltrCount), evtLog.Source)
+	if verbose {
+		logging.Verbosef(logging.Fields{"target": evtLog.Source, "event_count": evtCount, "filtered_count": evtFltrCount}, "Processed log #%d, total of %d events, filtered %d", logCount, evtCount, evtFltrCount)
 	}
 }
@@ -0,0 +1,361 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/reader"
+	"github.com/hb9tf/wireslacker/resolver"
+)
+
+var loadListsOnce sync.Once
+
+// loadLists loads the Yaesu lists used by the tests into the resolver (once). The room and the
+// node share the number 12345 so enriching one instead of the other can be told apart.
+func loadLists(t *testing.T) {
+	t.Helper()
+	loadListsOnce.Do(func() {
+		lists := `{
+			"nodes": {"LastUpdate": "2026-10-15T08:00:00Z", "Nodes": [
+				{"ID": "12345", "DTMFID": "12345", "Callsign": "HB9TF-ND", "Location": {"City": "Zurich", "State": "ZH", "Country": "Switzerland"}, "Comment": "Node comment"}
+			]},
+			"rooms": {"LastUpdate": "2026-10-15T08:00:00Z", "Rooms": [
+				{"ID": "12345", "DTMFID": "12345", "Name": "CQ-ZURICH", "Location": {"City": "Zurich", "State": "ZH", "Country": "Switzerland"}, "Comment": "Room comment"},
+				{"ID": "28001", "DTMFID": "28001", "Name": "ZÜRICH", "Location": {"City": "Zürich", "State": "ZH", "Country": "Schweiz"}, "Comment": "Grüezi mitenand, ä Rundspruch für alli Funkamatöre üsem Kanton Züri"}
+			]}
+		}`
+		path := filepath.Join(os.TempDir(), fmt.Sprintf("wireslacker-lists-%d.json", os.Getpid()))
+		defer os.Remove(path)
+		if err := os.WriteFile(path, []byte(lists), 0644); err != nil {
+			t.Fatal(err)
+		}
+		resolver.SetCacheFile(path)
+		defer resolver.SetCacheFile("")
+		if err := resolver.LoadCache(false); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// TestGetSlackMsgTimestamp ensures the attachment timestamp is marshalled as the decimal Unix time.
+func TestGetSlackMsgTimestamp(t *testing.T) {
+	ts := time.Date(2026, 10, 15, 8, 52, 36, 0, time.UTC)
+	evtLog := &data.Log{ID: "HB9TF-ND"}
+	evt := &data.Event{Ts: ts, Msg: "Program start"}
+	msg := getSlackMsg(evtLog, evt, nil, Config{}, false)
+	b, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf(`"ts":%d`, ts.Unix()); !strings.Contains(string(b), want) {
+		t.Errorf("getSlackMsg() = %s, want it to contain %s", b, want)
+	}
+}
+
+// TestEnrichConnected ensures a connected event is enriched from the room it connected to only,
+// without the node sharing its number overwriting the text of the room.
+func TestEnrichConnected(t *testing.T) {
+	loadLists(t)
+	evtLog := &data.Log{Source: "nodelog.html", ID: "HB9TF-ND, HB9TF(23456)"}
+	evt := &data.Event{Ts: time.Now(), Msg: "Connected to CQ-ZURICH(12345).", Category: data.CategoryConnected}
+	msg := getSlackMsg(evtLog, evt, nil, Config{}, false)
+
+	a := msg.Attachments[0]
+	want := "12345: CQ-ZURICH\nLocation: Zurich, ZH, Switzerland\nComment: Room comment"
+	if a.Text != want {
+		t.Errorf("getSlackMsg() text = %q, want %q", a.Text, want)
+	}
+	if len(a.Fields) != 0 {
+		t.Errorf("getSlackMsg() fields = %v, want none as the node must not be looked up", a.Fields)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"this is too long", 10, "this is t…"},
+		{"trailing space cut", 10, "trailing…"},
+		{"Zürich über alles", 8, "Zürich…"},
+		{"ääääääääää", 5, "ääää…"},
+		{"unlimited", 0, "unlimited"},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.max); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+	}
+}
+
+// TestEnrichTruncated ensures the text of enriched messages is cut at MaxTextLength characters
+// (not bytes) and ends in an ellipsis.
+func TestEnrichTruncated(t *testing.T) {
+	loadLists(t)
+	evtLog := &data.Log{Source: "nodelog.html", ID: "HB9TF-ND, HB9TF(23456)"}
+	evt := &data.Event{Ts: time.Now(), Msg: "Connected to ZÜRICH(28001).", Category: data.CategoryConnected}
+
+	full := getSlackMsg(evtLog, evt, nil, Config{}, false).Attachments[0].Text
+	if !strings.Contains(full, "Funkamatöre üsem Kanton Züri") {
+		t.Fatalf("getSlackMsg() text = %q, want the full comment", full)
+	}
+	const max = 60
+	text := getSlackMsg(evtLog, evt, nil, Config{MaxTextLength: max}, false).Attachments[0].Text
+	if !utf8.ValidString(text) {
+		t.Errorf("getSlackMsg() text = %q, not valid UTF-8", text)
+	}
+	if n := utf8.RuneCountInString(text); n > max {
+		t.Errorf("getSlackMsg() text has %d characters, want at most %d", n, max)
+	}
+	if !strings.HasSuffix(text, "…") || !strings.HasPrefix(full, strings.TrimSuffix(text, "…")) {
+		t.Errorf("getSlackMsg() text = %q, want the start of %q ending in an ellipsis", text, full)
+	}
+}
+
+// setNow makes now return ts for the duration of the test.
+func setNow(t *testing.T, ts time.Time) {
+	t.Helper()
+	orig := now
+	now = func() time.Time { return ts }
+	t.Cleanup(func() { now = orig })
+}
+
+// recorder is a Notifier recording the messages posted.
+type recorder struct {
+	mu   sync.Mutex
+	msgs []*data.Message
+}
+
+func (r *recorder) Post(msg *data.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+// pretexts returns the pretext of each attachment posted, in order.
+func (r *recorder) pretexts() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var pretexts []string
+	for _, msg := range r.msgs {
+		for _, a := range msg.Attachments {
+			pretexts = append(pretexts, a.Pretext)
+		}
+	}
+	return pretexts
+}
+
+// TestFilterNotBefore ensures only events with a valid timestamp after notBefore pass.
+func TestFilterNotBefore(t *testing.T) {
+	notBefore := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	evtLog := &data.Log{Source: "nodelog.html", ID: "HB9TF-ND"}
+	tests := []struct {
+		desc string
+		evt  *data.Event
+		want bool
+	}{
+		{"before", &data.Event{Ts: notBefore.Add(-time.Second), Msg: "Call Start No.12345"}, true},
+		{"at", &data.Event{Ts: notBefore, Msg: "Call Start No.12345"}, true},
+		{"after", &data.Event{Ts: notBefore.Add(time.Second), Msg: "Call Start No.12345"}, false},
+		{"invalid timestamp", &data.Event{Ts: notBefore.Add(time.Second), TsInvalid: true, Msg: "Call Start No.12345"}, true},
+		{"default filter", &data.Event{Ts: notBefore.Add(time.Second), Msg: "Browser connected from 192.168.1.2"}, true},
+	}
+	for _, tt := range tests {
+		if got := filter(evtLog, tt.evt, notBefore, Config{}); got != tt.want {
+			t.Errorf("filter(%s) = %t, want %t", tt.desc, got, tt.want)
+		}
+	}
+}
+
+// TestRunBackfill ensures only the events within Since are posted for a new source, at most
+// the last maxBackfillEvents of them, and that the limit does not apply once the source is known.
+func TestRunBackfill(t *testing.T) {
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	setNow(t, start)
+	logOf := func(from, to int) *data.Log {
+		evtLog := &data.Log{Source: "nodelog.html", ID: "HB9TF-ND"}
+		for i := from; i < to; i++ {
+			evtLog.Events = append(evtLog.Events, &data.Event{
+				Ts:  start.Add(time.Duration(i) * time.Minute),
+				Msg: fmt.Sprintf("Call Start No.%05d", i+100),
+			})
+		}
+		return evtLog
+	}
+	var want []string
+	for i := 0; i < maxBackfillEvents; i++ {
+		want = append(want, fmt.Sprintf("HB9TF-ND: Call Start No.%05d", i-maxBackfillEvents+101))
+	}
+	for i := 1; i <= maxBackfillEvents+5; i++ {
+		want = append(want, fmt.Sprintf("HB9TF-ND: Call Start No.%05d", i+100))
+	}
+
+	logChan := make(chan *data.Log, 2)
+	// Older than Since, within Since but beyond the limit, and the last maxBackfillEvents.
+	logChan <- logOf(-90, 1)
+	// All new events are posted once the source is known.
+	logChan <- logOf(-90, maxBackfillEvents+6)
+	close(logChan)
+	state, err := LoadState("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	Run(logChan, []Notifier{rec}, state, Config{Since: time.Hour, Enrichers: []Enricher{}}, false)
+
+	got := rec.pretexts()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("posted:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestRunSections ensures the route and channel of a target apply to all the logs of a page with
+// several sections, and that each of them is tracked apart.
+func TestRunSections(t *testing.T) {
+	setNow(t, time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC))
+	const source = "http://dashboard/nodelog.html"
+	page := strings.Join([]string{
+		"NODE: HB9TF-ND , HB9TF(12345)",
+		"2026-10-15 12:00:00 Call Start No.23456",
+		"NODE: DL1XYZ-ND , DL1XYZ(23456)",
+		"2026-10-15 11:00:00 Call Start No.12345",
+		"2026-10-15 12:30:00 Call End No.12345",
+	}, "\n")
+	logs, err := reader.Parse(strings.NewReader(page), source, time.UTC, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logChan := make(chan *data.Log, len(logs))
+	for _, l := range logs {
+		logChan <- l
+	}
+	close(logChan)
+	state, err := LoadState("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def, routed := &recorder{}, &recorder{}
+	cfg := Config{
+		Routes:    map[string][]Notifier{source: {routed}},
+		Channels:  map[string]string{source: "#dashboard"},
+		Enrichers: []Enricher{},
+	}
+	Run(logChan, []Notifier{def}, state, cfg, false)
+
+	if got := def.pretexts(); len(got) != 0 {
+		t.Errorf("posted to the default notifier: %q", got)
+	}
+	want := []string{
+		"HB9TF-ND, HB9TF(12345): Call Start No.23456",
+		"DL1XYZ-ND, DL1XYZ(23456): Call Start No.12345",
+		"DL1XYZ-ND, DL1XYZ(23456): Call End No.12345",
+	}
+	if got := routed.pretexts(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("posted:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	for _, msg := range routed.msgs {
+		if msg.Channel != "#dashboard" {
+			t.Errorf("posted to channel %q, want %q", msg.Channel, "#dashboard")
+		}
+	}
+}
+
+// TestRunTargetChannel ensures the channel configured for a target with several sections, keyed
+// like in the configuration, applies to the logs read from it.
+func TestRunTargetChannel(t *testing.T) {
+	setNow(t, time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "nodelog.txt")
+	page := strings.Join([]string{
+		"NODE: HB9TF-ND , HB9TF(12345)",
+		"2026-10-15 12:00:00 Call Start No.23456",
+		"NODE: DL1XYZ-ND , DL1XYZ(23456)",
+		"2026-10-15 11:00:00 Call Start No.12345",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(page), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := "file://" + path
+	r, err := reader.New(target, reader.Options{}, time.UTC, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, err := r.ReadAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	logChan := make(chan *data.Log, len(logs))
+	for _, l := range logs {
+		logChan <- l
+	}
+	close(logChan)
+	state, err := LoadState("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	cfg := Config{
+		Channels:  map[string]string{reader.Source(target): "#dashboard"},
+		Enrichers: []Enricher{},
+	}
+	Run(logChan, []Notifier{rec}, state, cfg, false)
+
+	if len(rec.msgs) != 2 {
+		t.Fatalf("posted %d messages, want 2", len(rec.msgs))
+	}
+	for _, msg := range rec.msgs {
+		if msg.Channel != "#dashboard" {
+			t.Errorf("posted %s to channel %q, want %q", msg.LogID, msg.Channel, "#dashboard")
+		}
+	}
+}
+
+// TestRunBatchRecent ensures each batched event is recorded with its own attachment, even if an
+// Enricher adds others.
+func TestRunBatchRecent(t *testing.T) {
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	setNow(t, start)
+	evtLog := &data.Log{Source: "nodelog.html", ID: "HB9TF-ND"}
+	for i := 1; i <= 3; i++ {
+		evtLog.Events = append(evtLog.Events, &data.Event{
+			Ts:  start.Add(time.Duration(i) * time.Second),
+			Msg: fmt.Sprintf("Call Start No.%05d", i),
+		})
+	}
+	logChan := make(chan *data.Log, 1)
+	logChan <- evtLog
+	close(logChan)
+	state, err := LoadState("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recent := NewRecent(10)
+	extra := EnricherFunc(func(evtLog *data.Log, evt *data.Event, msg *data.Message) *data.Message {
+		msg.Attachments = append(msg.Attachments, data.Attachment{Pretext: "extra"})
+		return msg
+	})
+	cfg := Config{BatchWindow: time.Minute, Enrichers: []Enricher{extra}, Recent: recent}
+	Run(logChan, []Notifier{&recorder{}}, state, cfg, false)
+
+	events := recent.Events()
+	if len(events) != 3 {
+		t.Fatalf("recorded %d events, want 3", len(events))
+	}
+	for _, e := range events {
+		if want := "HB9TF-ND: " + e.Event.Msg; e.Attachment.Pretext != want {
+			t.Errorf("recorded %q with attachment %q, want %q", e.Event.Msg, e.Attachment.Pretext, want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package processor
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting how many posts are sent per second. Posts exceeding
+// the limit are delayed (not dropped) in the order they arrived.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing rate posts per second on average and bursts of
+// up to burst posts. It returns nil (no limit) if rate is not positive.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until the next post is allowed and returns how long it waited.
+// A nil RateLimiter never blocks.
+func (l *RateLimiter) Wait() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// Take the token right away, even if it is not available yet, so concurrent callers
+	// queue up behind each other instead of racing for the next token.
+	l.tokens--
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	time.Sleep(wait)
+	return wait
+}
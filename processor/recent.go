@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/hb9tf/wireslacker/data"
+)
+
+// RecentEvent is an event kept by Recent, together with the log it is from and its enrichment.
+type RecentEvent struct {
+	Source string      `json:"source"`
+	LogID  string      `json:"logId"`
+	Type   string      `json:"type"`
+	Event  *data.Event `json:"event"`
+	// Attachment is the (enriched) attachment the event is posted as.
+	Attachment data.Attachment `json:"attachment"`
+}
+
+// NewRecent creates a new Recent keeping the last size events.
+func NewRecent(size int) *Recent {
+	return &Recent{
+		events: make([]*RecentEvent, size),
+	}
+}
+
+// Recent is a ring buffer of the most recent events which passed the filters.
+// It implements http.Handler to serve them as JSON, the most recent first.
+type Recent struct {
+	mu     sync.Mutex
+	events []*RecentEvent
+	// next is the index the next event is written to.
+	next int
+}
+
+// Add records the event of evtLog and the attachment it is posted as, replacing the oldest
+// event if the buffer is full.
+func (r *Recent) Add(evtLog *data.Log, evt *data.Event, attachment data.Attachment) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.events) == 0 {
+		return
+	}
+	r.events[r.next] = &RecentEvent{evtLog.Source, evtLog.ID, evtLog.Type, evt, attachment}
+	r.next = (r.next + 1) % len(r.events)
+}
+
+// Events returns the recorded events, the most recent first.
+func (r *Recent) Events() []*RecentEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := []*RecentEvent{}
+	for i := 1; i <= len(r.events); i++ {
+		evt := r.events[(r.next-i+len(r.events))%len(r.events)]
+		if evt == nil {
+			break
+		}
+		events = append(events, evt)
+	}
+	return events
+}
+
+// ServeHTTP serves the recorded events as a JSON array, the most recent first.
+func (r *Recent) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set(httpContentType, httpJSON)
+	if err := json.NewEncoder(w).Encode(r.Events()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
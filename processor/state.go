@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/fileutil"
+)
+
+// logKey returns the key the state of evtLog is kept under: its source and ID, so the logs of
+// the nodes or rooms of a page with several sections are tracked apart. Logs without an ID are
+// kept under their source only.
+func logKey(evtLog *data.Log) string {
+	if evtLog.ID == "" {
+		return evtLog.Source
+	}
+	return evtLog.Source + "#" + evtLog.ID
+}
+
+// State keeps track of the timestamp of the last posted event per log (see logKey). If a path is
+// provided, the state is persisted so a restart neither re-posts nor misses events.
+type State struct {
+	path string
+
+	mu        sync.Mutex
+	notBefore map[string]time.Time
+}
+
+// LoadState loads the state from the file at path. A file which does not exist (yet) results
+// in an empty state. If path is empty, the state is kept in memory only.
+func LoadState(path string) (*State, error) {
+	s := &State{
+		path:      path,
+		notBefore: map[string]time.Time{},
+	}
+	if path == "" {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.notBefore); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NotBefore returns the timestamp of the last posted event of the provided source or def
+// if nothing has been posted for it yet.
+func (s *State) NotBefore(source string, def time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ts, ok := s.notBefore[source]; ok {
+		return ts
+	}
+	return def
+}
+
+// Known returns true if an event has been posted for the provided source.
+func (s *State) Known(source string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.notBefore[source]
+	return ok
+}
+
+// Update records ts as the timestamp of the last posted event of the provided source and
+// persists the state if a path has been provided.
+func (s *State) Update(source string, ts time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notBefore[source] = ts
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.notBefore, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(s.path, data)
+}
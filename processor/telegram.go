@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/logging"
+)
+
+const (
+	// telegramAPIURL is the Telegram Bot API endpoint, formatted with the bot token.
+	telegramAPIURL = "https://api.telegram.org/bot%s/sendMessage"
+	// telegramParseMode is the formatting used in the messages.
+	telegramParseMode = "Markdown"
+)
+
+var (
+	// telegramEscaper escapes the characters which have a special meaning in Telegram Markdown.
+	telegramEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+)
+
+// telegramMessage is the payload of the Telegram sendMessage API.
+type telegramMessage struct {
+	ChatID                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+// NewTelegram creates a new Telegram notifier posting to the chat using the bot token.
+func NewTelegram(token, chatID string, dry bool, verbose bool) *Telegram {
+	return &Telegram{
+		token,
+		chatID,
+		&http.Client{Transport: httpTransport},
+		dry,
+		verbose,
+	}
+}
+
+// Telegram implements the Notifier interface and posts messages to a Telegram chat using a bot.
+type Telegram struct {
+	token   string
+	chatID  string
+	client  *http.Client
+	dry     bool
+	verbose bool
+}
+
+// telegramMarkdown escapes s for Telegram Markdown while converting Slack formatted links
+// (<url|text>) into Markdown links.
+func telegramMarkdown(s string) string {
+	var sb strings.Builder
+	last := 0
+	for _, m := range slackLinkRE.FindAllStringSubmatchIndex(s, -1) {
+		sb.WriteString(telegramEscaper.Replace(s[last:m[0]]))
+		fmt.Fprintf(&sb, "[%s](%s)", telegramEscaper.Replace(s[m[4]:m[5]]), s[m[2]:m[3]])
+		last = m[1]
+	}
+	sb.WriteString(telegramEscaper.Replace(s[last:]))
+	return sb.String()
+}
+
+// toTelegram renders the message as Telegram Markdown text.
+func toTelegram(msg *data.Message) string {
+	var parts []string
+	if msg.Text != "" {
+		parts = append(parts, telegramMarkdown(msg.Text))
+	}
+	for _, a := range msg.Attachments {
+		var lines []string
+		if a.Pretext != "" {
+			lines = append(lines, "*"+telegramMarkdown(a.Pretext)+"*")
+		}
+		if a.Title != "" {
+			title := telegramMarkdown(a.Title)
+			if a.TitleLink != "" {
+				title = fmt.Sprintf("[%s](%s)", telegramEscaper.Replace(a.Title), a.TitleLink)
+			}
+			lines = append(lines, title)
+		}
+		if a.Text != "" {
+			lines = append(lines, telegramMarkdown(a.Text))
+		}
+		for _, f := range a.Fields {
+			lines = append(lines, fmt.Sprintf("*%s:* %s", telegramEscaper.Replace(f.Title), telegramMarkdown(f.Value)))
+		}
+		if a.Footer != "" {
+			lines = append(lines, "_"+telegramEscaper.Replace(a.Footer)+"_")
+		}
+		parts = append(parts, strings.Join(lines, "\n"))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// Post sends the provided message to the Telegram chat.
+func (t *Telegram) Post(msg *data.Message) error {
+	data, err := json.Marshal(&telegramMessage{
+		ChatID:                t.chatID,
+		Text:                  toTelegram(msg),
+		ParseMode:             telegramParseMode,
+		DisableWebPagePreview: true,
+	})
+	if err != nil {
+		return err
+	}
+	if t.dry {
+		return dryRun("Telegram", data)
+	}
+	if t.verbose {
+		logging.Verbosef(nil, "Posting Telegram message: %s", data)
+	}
+	_, err = postJSON(t.client, fmt.Sprintf(telegramAPIURL, t.token), nil, data, t.verbose)
+	return err
+}
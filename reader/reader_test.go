@@ -0,0 +1,240 @@
+package reader
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// TestParseGolden parses each saved log in testdata and compares the result with the golden
+// file next to it, as written by -export=jsonl.
+func TestParseGolden(t *testing.T) {
+	var paths []string
+	for _, pattern := range []string{"testdata/*.html", "testdata/*.txt"} {
+		m, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, m...)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no saved logs found in testdata")
+	}
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			// The golden files are exported from the root of the repository.
+			logs, err := Parse(f, "reader/"+filepath.ToSlash(path), time.UTC, "", false)
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			var got []byte
+			for i, l := range logs {
+				b, err := json.Marshal(l)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i > 0 {
+					got = append(got, '\n')
+				}
+				got = append(got, b...)
+			}
+			golden := strings.TrimSuffix(path, filepath.Ext(path)) + ".golden.jsonl"
+			if *update {
+				if err := os.WriteFile(golden, append(got, '\n'), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != strings.TrimSpace(string(want)) {
+				t.Errorf("Parse() = %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+// TestParseVersions ensures the node or room info is found in the layout of each supported
+// Wires-X version.
+func TestParseVersions(t *testing.T) {
+	tests := []struct {
+		file        string
+		wantVersion string
+		wantID      string
+		wantEvents  int
+	}{
+		{"nodelog-1.4.html", "WIRES-X Ver.1.400", "HB9TF-ND, HB9TF(12345)", 6},
+		{"nodelog-1.5.html", "WIRES-X Ver.1.520", "DL1XYZ-ND, DL1XYZ(23456)", 3},
+		{"roomlog.html", "WIRES-X Ver.1.550", "CQ-ZURICH, CQ-ZURICH(28000)", 3},
+		{"nodelog-1.6.html", "WIRES-X Ver.1.600", "HB9TF-ND, HB9TF(12345)", 3},
+		{"roomlog-1.6.html", "WIRES-X Ver.1.600", "EUROPE, EUROPE(20880)", 2},
+	}
+	for _, tt := range tests {
+		b, err := os.ReadFile(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs, err := Parse(bytes.NewReader(b), tt.file, time.UTC, "", false)
+		if err != nil {
+			t.Fatalf("Parse(%s) failed: %v", tt.file, err)
+		}
+		l := logs[0]
+		if l.WiresVersion != tt.wantVersion || l.ID != tt.wantID || len(l.Events) != tt.wantEvents {
+			t.Errorf("Parse(%s) = version %q, ID %q, %d events, want %q, %q, %d events", tt.file, l.WiresVersion, l.ID, len(l.Events), tt.wantVersion, tt.wantID, tt.wantEvents)
+		}
+	}
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"a<br>b", []string{"a", "b"}},
+		{"a<BR>b", []string{"a", "b"}},
+		{"a<br/>b", []string{"a", "b"}},
+		{"a<br />b<Br  />c", []string{"a", "b", "c"}},
+		{"a\nb\r\nc", []string{"a", "b", "c"}},
+		// Newlines are kept inside of lines separated by <br> tags.
+		{"a\n<br>b", []string{"a\n", "b"}},
+	}
+	for _, tt := range tests {
+		if got := splitLines(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitLines(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+// TestParseLineBreaks ensures the events of a log mixing all variants of the line break tag
+// are split.
+func TestParseLineBreaks(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "nodelog-br.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	logs, err := Parse(f, "nodelog-br.html", time.UTC, "", false)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	l := logs[0]
+	var msgs []string
+	for _, evt := range l.Events {
+		msgs = append(msgs, evt.Msg)
+	}
+	want := []string{
+		"Connected to CQ-ZURICH(28000).",
+		"Call Start No.23456 HB9ABC",
+		"Disconnected from CQ-ZURICH(28000)",
+	}
+	if !reflect.DeepEqual(msgs, want) {
+		t.Errorf("Parse() messages = %q, want %q", msgs, want)
+	}
+}
+
+// TestParseEntities ensures HTML entities in the log info and events are unescaped.
+func TestParseEntities(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "nodelog-entities.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	logs, err := Parse(f, "nodelog-entities.html", time.UTC, "", false)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	l := logs[0]
+	if want := "HB9TF&CO-ND, HB9TF(12345)"; l.ID != want {
+		t.Errorf("ID = %q, want %q", l.ID, want)
+	}
+	if want := "R&D-ROOM(28001)"; l.ConnectedTo != want {
+		t.Errorf("ConnectedTo = %q, want %q", l.ConnectedTo, want)
+	}
+	wantMsgs := []string{
+		"Connected to R&D-ROOM(28001).",
+		"Call Start No.23456 O'BRIEN",
+		`Message from <HB9ABC> "hello"`,
+	}
+	var msgs []string
+	for _, evt := range l.Events {
+		msgs = append(msgs, evt.Msg)
+	}
+	if !reflect.DeepEqual(msgs, wantMsgs) {
+		t.Errorf("event messages = %q, want %q", msgs, wantMsgs)
+	}
+	if want := "2026/10/15 12:01:00 Call Start No.23456 O&#39;BRIEN"; len(l.Events) > 1 && l.Events[1].Raw != want {
+		t.Errorf("raw line = %q, want it kept escaped as %q", l.Events[1].Raw, want)
+	}
+}
+
+// TestParseDuplicates ensures repeated lines are dropped, including the ones whose timestamp
+// cannot be parsed.
+func TestParseDuplicates(t *testing.T) {
+	log := strings.Join([]string{
+		"NODE: HB9TF-ND , HB9TF(12345)",
+		"2026-10-15 12:00:00 Call Start No.23456",
+		"2026-10-15 12:00:00 Call Start No.23456",
+		"2026-13-45 12:00:00 Call Start No.23456",
+		"2026-13-45 12:00:00 Call Start No.23456",
+		"2026-13-46 12:00:00 Call Start No.23456",
+	}, "\n")
+	logs, err := Parse(strings.NewReader(log), "nodelog.txt", time.UTC, "", false)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	l := logs[0]
+	var raws []string
+	for _, evt := range l.Events {
+		raws = append(raws, evt.Raw)
+	}
+	want := []string{
+		"2026-10-15 12:00:00 Call Start No.23456",
+		"2026-13-45 12:00:00 Call Start No.23456",
+		"2026-13-46 12:00:00 Call Start No.23456",
+	}
+	if !reflect.DeepEqual(raws, want) {
+		t.Errorf("events = %q, want %q", raws, want)
+	}
+}
+
+// TestParseSections ensures the logs of a page with several NODE/ROOM sections keep the target
+// as their source and are told apart by their ID.
+func TestParseSections(t *testing.T) {
+	page := strings.Join([]string{
+		"NODE: HB9TF-ND , HB9TF(12345)",
+		"2026-10-15 12:00:00 Call Start No.23456",
+		"NODE: DL1XYZ-ND , DL1XYZ(23456)",
+		"2026-10-15 11:00:00 Call Start No.12345",
+		"2026-10-15 12:30:00 Call End No.12345",
+	}, "\n")
+	logs, err := Parse(strings.NewReader(page), "http://dashboard/nodelog.html", time.UTC, "", false)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	var got []string
+	for _, l := range logs {
+		got = append(got, fmt.Sprintf("%s %s %d", l.Source, l.ID, len(l.Events)))
+	}
+	want := []string{
+		"http://dashboard/nodelog.html HB9TF-ND, HB9TF(12345) 1",
+		"http://dashboard/nodelog.html DL1XYZ-ND, DL1XYZ(23456) 2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Parse() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+package reader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/logging"
+)
+
+const (
+	// tcpScheme is the prefix of a target pointing to a log streamed over a TCP socket.
+	tcpScheme = "tcp://"
+)
+
+// Stream is implemented by readers which receive the log as a live stream instead of polling it.
+type Stream interface {
+	Log
+	// Stream connects to the log and sends a data.Log for each received event to logChan until
+	// ctx is cancelled (returning nil) or the connection is lost (returning an error).
+	Stream(ctx context.Context, logChan chan<- *data.Log) error
+}
+
+// TCP implements the Log and Stream interfaces and reads the log lines streamed over a TCP socket
+// (e.g. tcp://host:port).
+type TCP struct {
+	target     string
+	addr       string
+	timeout    time.Duration
+	loc        *time.Location
+	timeFormat string
+	verbose    bool
+}
+
+func (r *TCP) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: r.timeout}
+	return dialer.DialContext(ctx, "tcp", r.addr)
+}
+
+// Read connects to the socket and parses whatever is received within the timeout into data.Log
+// format. Use Stream to receive the events as they happen.
+func (r *TCP) Read(ctx context.Context) (*data.Log, error) {
+	logs, err := r.ReadAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return logs[0], nil
+}
+
+// ReadAll connects to the socket and parses whatever is received within the timeout into one
+// data.Log per node or room.
+func (r *TCP) ReadAll(ctx context.Context) ([]*data.Log, error) {
+	conn, err := r.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(r.timeout))
+	b, err := ioutil.ReadAll(conn)
+	if err != nil {
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			return nil, err
+		}
+	}
+	return Parse(bytes.NewReader(b), r.target, r.loc, r.timeFormat, r.verbose)
+}
+
+// Stream connects to the socket and sends a data.Log for each received event line.
+func (r *TCP) Stream(ctx context.Context, logChan chan<- *data.Log) error {
+	conn, err := r.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// Close the connection on cancellation to unblock the scanner.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	if r.verbose {
+		logging.Verbosef(logging.Fields{"target": r.target}, "Connected to log stream %q", r.target)
+	}
+
+	// The details of the log (e.g. its ID) are only sent once and apply to all following events.
+	info := &data.Log{}
+	formats := timeFormats(r.timeFormat)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		l := parseAll(scanner.Text(), r.target, r.loc, formats, r.verbose)[0]
+		if l.Type != "" {
+			info.Type = l.Type
+		}
+		if l.ID != "" {
+			info.ID = l.ID
+		}
+		if l.WiresVersion != "" {
+			info.WiresVersion = l.WiresVersion
+		}
+		if l.ConnectedTo != "" {
+			info.ConnectedTo = l.ConnectedTo
+		}
+		if l.Freq != "" {
+			info.Freq = l.Freq
+		}
+		if l.Status != "" {
+			info.Status = l.Status
+		}
+		if len(l.Events) == 0 {
+			continue
+		}
+		l.Type, l.ID, l.WiresVersion, l.ConnectedTo, l.Freq, l.Status = info.Type, info.ID, info.WiresVersion, info.ConnectedTo, info.Freq, info.Status
+		select {
+		case logChan <- l:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("connection closed by %s", r.addr)
+}
@@ -0,0 +1,99 @@
+package resolver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/fileutil"
+	"github.com/hb9tf/wireslacker/logging"
+)
+
+var (
+	// cacheFile is the path of the file the active lists are persisted to, disabled if empty.
+	cacheFile   string
+	cacheFileMu = &sync.Mutex{}
+)
+
+// cache is the on-disk representation of the active lists.
+type cache struct {
+	Nodes *data.ActiveNodes `json:"nodes,omitempty"`
+	Rooms *data.ActiveRooms `json:"rooms,omitempty"`
+}
+
+// SetCacheFile sets the path of the file the active lists are persisted to after each
+// successful update. It should be called before the first Update.
+func SetCacheFile(path string) {
+	cacheFileMu.Lock()
+	defer cacheFileMu.Unlock()
+	cacheFile = path
+}
+
+// LoadCache loads the active lists from the cache file as a warm cache until the first
+// successful update. A cache file which does not exist (yet) is not an error.
+func LoadCache(verbose bool) error {
+	cacheFileMu.Lock()
+	defer cacheFileMu.Unlock()
+	if cacheFile == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(cacheFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	c := &cache{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return err
+	}
+
+	if c.Nodes != nil {
+		activeNodesMu.Lock()
+		if activeNodes == nil {
+			activeNodes = c.Nodes
+		}
+		activeNodesMu.Unlock()
+		if verbose {
+			logging.Verbosef(logging.Fields{"list": "nodes", "count": len(c.Nodes.Nodes)}, "Loaded %d nodes from cache %q, last updated %s ago", len(c.Nodes.Nodes), cacheFile, time.Since(c.Nodes.LastUpdate).Round(time.Second))
+		}
+	}
+	if c.Rooms != nil {
+		activeRoomsMu.Lock()
+		if activeRooms == nil {
+			activeRooms = c.Rooms
+		}
+		activeRoomsMu.Unlock()
+		if verbose {
+			logging.Verbosef(logging.Fields{"list": "rooms", "count": len(c.Rooms.Rooms)}, "Loaded %d rooms from cache %q, last updated %s ago", len(c.Rooms.Rooms), cacheFile, time.Since(c.Rooms.LastUpdate).Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// saveCache persists the active lists to the cache file if one has been set.
+func saveCache() error {
+	cacheFileMu.Lock()
+	defer cacheFileMu.Unlock()
+	if cacheFile == "" {
+		return nil
+	}
+
+	activeNodesMu.RLock()
+	activeRoomsMu.RLock()
+	b, err := json.Marshal(&cache{
+		Nodes: activeNodes,
+		Rooms: activeRooms,
+	})
+	activeRoomsMu.RUnlock()
+	activeNodesMu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteAtomic(cacheFile, b)
+}
@@ -0,0 +1,55 @@
+package resolver
+
+import (
+	"math"
+	"sort"
+
+	"github.com/hb9tf/wireslacker/data"
+)
+
+const (
+	// earthRadiusKm is the mean radius of the earth in kilometers.
+	earthRadiusKm = 6371.0
+)
+
+// hasCoordinates returns true if the location carries parseable coordinates.
+func hasCoordinates(l *data.Location) bool {
+	return l != nil && (l.Lat != 0 || l.Lon != 0)
+}
+
+// distanceKm returns the great-circle distance between two coordinates (in decimal degrees)
+// in kilometers using the haversine formula.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// FindNodesNear returns all active nodes within radiusKm of the given coordinates (in decimal
+// degrees), sorted by distance ascending. Nodes without coordinates are skipped.
+func FindNodesNear(lat, lon, radiusKm float64) []*data.Node {
+	activeNodesMu.RLock()
+	defer activeNodesMu.RUnlock()
+	if activeNodes == nil {
+		return nil
+	}
+	var nodes []*data.Node
+	distances := map[*data.Node]float64{}
+	for _, n := range activeNodes.Nodes {
+		if !hasCoordinates(n.Location) {
+			continue
+		}
+		d := distanceKm(lat, lon, n.Location.Lat, n.Location.Lon)
+		if d > radiusKm {
+			continue
+		}
+		nodes = append(nodes, n)
+		distances[n] = d
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return distances[nodes[i]] < distances[nodes[j]]
+	})
+	return nodes
+}
@@ -1,75 +1,277 @@
 package resolver
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"html"
 	"io/ioutil"
-	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/logging"
+	"github.com/hb9tf/wireslacker/metrics"
 )
 
 const (
-	activeNodesURL = "https://www.yaesu.com/jp/en/wires-x/id/active_node.php"
-	activeRoomsURL = "https://www.yaesu.com/jp/en/wires-x/id/active_room.php"
+	// DefaultNodesURL is the URL of the Active Nodes list provided by Yaesu.
+	DefaultNodesURL = "https://www.yaesu.com/jp/en/wires-x/id/active_node.php"
+	// DefaultRoomsURL is the URL of the Active Rooms list provided by Yaesu.
+	DefaultRoomsURL = "https://www.yaesu.com/jp/en/wires-x/id/active_room.php"
+	// DefaultUserAgent is the User-Agent sent when reading the lists unless changed by SetHeader.
+	DefaultUserAgent = "wireslacker"
 
 	// updateTimeFormat is the date/time format used in the Active Nodes list.
 	updateTimeFormat = "02 Jan 2006 15:04:05 MST"
+
+	// DefaultUpdateInterval is the recommended interval in which to refresh the lists.
+	DefaultUpdateInterval = time.Duration(20 * time.Minute)
+
+	// dmsPattern matches degrees, optionally followed by minutes and seconds, e.g. 47° 23' 10.5".
+	dmsPattern = `([0-9]+(?:\.[0-9]+)?)°?(?:[[:space:]]+([0-9]+(?:\.[0-9]+)?)'?)?(?:[[:space:]]+([0-9]+(?:\.[0-9]+)?)(?:"|'')?)?`
+)
+
+// Precedence of the matches of FindNodes and FindRooms, lower is preferred.
+const (
+	matchID = iota
+	matchName
+	matchDTMFID
 )
 
 var (
-	// updateInterval defines how often the nodes list should be refreshed.
-	updateInterval = time.Duration(20 * time.Minute)
+	// activeNodesURL and activeRoomsURL are the URLs the lists are read from.
+	activeNodesURL = DefaultNodesURL
+	activeRoomsURL = DefaultRoomsURL
+
+	// updateJitter is the fraction by which the interval between two updates is randomly varied.
+	updateJitter float64
+
 	// httpTimeout defines how long to wait for a response before giving up.
 	httpTimeout = time.Duration(30 * time.Second)
+	// httpTransport is the transport used to read the lists, the default one if nil.
+	httpTransport http.RoundTripper
+	// httpTLSConfig and httpProxy are the TLS configuration and proxy of httpTransport.
+	httpTLSConfig *tls.Config
+	httpProxy     *url.URL
+	// httpHeader are additional headers sent with each request, e.g. the User-Agent.
+	httpHeader = http.Header{"User-Agent": {DefaultUserAgent}}
 
 	// updateTimeRE is the regexp used to determine the last update time of the list.
 	updateTimeRE = regexp.MustCompile("<p class=.*><span>Update every .*</span> <span>(.*)</span></p>")
 	// nodeRE is the regexp used to parse the node information.
-	nodeRE = regexp.MustCompile("dataList\\[[0-9]+\\] = {id:\"(.*)\", dtmf_id:\"([0-9]+)\", call_sign:\"(.*)\", ana_dig:\"(.*)\", city:\"(.*)\", state:\"(.*)\", country:\"(.*)\", freq:\"(.*)\", sql:\"(.*)\", lat:\"(.*)\", lon:\"(.*)\", comment:\"(.*)\"};")
-	// roomRE is the regexp used to parse the room information.
-	roomRE = regexp.MustCompile("dataList\\[[0-9]+\\] = {id:\"(.*)\", dtmp:\"([0-9]+)\", act:\"(.*)\", room_name:\"(.*)\", city:\"(.*)\", state:\"(.*)\", country:\"(.*)\", comment:\"(.*)\"};")
+	// The fields are not escaped in the lists, so each field ends at the first marker of the next
+	// one and the comment (the last field, most likely to contain quotes and commas) takes the
+	// rest of the line.
+	nodeRE = regexp.MustCompile("dataList\\[[0-9]+\\] = {id:\"(.*?)\", dtmf_id:\"([0-9]+)\", call_sign:\"(.*?)\", ana_dig:\"(.*?)\", city:\"(.*?)\", state:\"(.*?)\", country:\"(.*?)\", freq:\"(.*?)\", sql:\"(.*?)\", lat:\"(.*?)\", lon:\"(.*?)\", comment:\"(.*)\"};")
+	// roomRE is the regexp used to parse the room information, see nodeRE.
+	roomRE = regexp.MustCompile("dataList\\[[0-9]+\\] = {id:\"(.*?)\", dtmp:\"([0-9]+)\", act:\"(.*?)\", room_name:\"(.*?)\", city:\"(.*?)\", state:\"(.*?)\", country:\"(.*?)\", comment:\"(.*)\"};")
+
+	// latRE and lonRE are the regexps used to parse the coordinates, e.g. "N:47 23' 10". Minutes and
+	// seconds are optional and each component may have decimals (e.g. "N:47 23.5'" or "N:47.39").
+	latRE = regexp.MustCompile("([NS])[[:space:]]*:?[[:space:]]*" + dmsPattern)
+	lonRE = regexp.MustCompile("([EW])[[:space:]]*:?[[:space:]]*" + dmsPattern)
+
+	updatesTotal        = metrics.NewCounter("resolver_updates_total", "Number of updates of the Yaesu lists.", "list", "result")
+	lastUpdateTimestamp = metrics.NewGauge("resolver_last_update_timestamp_seconds", "Time of the last successful update of the Yaesu lists.", "list")
 
-	latRE = regexp.MustCompile("([NS]):([0-9]+) ([0-9]+)' ([0-9]+)")
-	lonRE = regexp.MustCompile("([EW]):([0-9]+) ([0-9]+)' ([0-9]+)")
+	// errNotModified is returned by read if the target did not change since the last read.
+	errNotModified = errors.New("not modified")
+	// lastValidators contains the cache validators of the last successful read per target.
+	lastValidators = map[string]validators{}
+	validatorsMu   = &sync.Mutex{}
 
 	activeNodes   *data.ActiveNodes
 	activeNodesMu = &sync.RWMutex{}
 	activeRooms   *data.ActiveRooms
 	activeRoomsMu = &sync.RWMutex{}
+
+	// activity is signalled by Activity to let AutoUpdateAdaptive update the lists more often.
+	activity = make(chan struct{}, 1)
 )
 
-func convertLatLon(lat, lon string) (string, string, error) {
+// convertLatLon converts the DMS coordinates used in the Active Nodes list (e.g. "N:47 23' 10")
+// into decimal degrees. Southern latitudes and western longitudes are negative.
+func convertLatLon(lat, lon string) (float64, float64, error) {
 	matchLat := latRE.FindStringSubmatch(lat)
-	if len(matchLat) < 2 {
-		return "", "", fmt.Errorf("unable to determine latitude: %s", lat)
+	if len(matchLat) < 5 {
+		return 0, 0, fmt.Errorf("unable to determine latitude: %q", lat)
 	}
 	matchLon := lonRE.FindStringSubmatch(lon)
-	if len(matchLon) < 2 {
-		return "", "", fmt.Errorf("unable to determine longitude: %s", lon)
+	if len(matchLon) < 5 {
+		return 0, 0, fmt.Errorf("unable to determine longitude: %q", lon)
+	}
+	decLat, err := dmsToDecimal(matchLat[1], matchLat[2], matchLat[3], matchLat[4])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to convert latitude %q: %v", lat, err)
+	}
+	decLon, err := dmsToDecimal(matchLon[1], matchLon[2], matchLon[3], matchLon[4])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to convert longitude %q: %v", lon, err)
+	}
+	return decLat, decLon, nil
+}
+
+// dmsToDecimal converts degrees, minutes and seconds into decimal degrees. Empty minutes and
+// seconds count as 0. The hemisphere (N, S, E or W) determines the sign of the result.
+func dmsToDecimal(hemisphere, deg, mins, secs string) (float64, error) {
+	d, err := strconv.ParseFloat(deg, 64)
+	if err != nil {
+		return 0, err
+	}
+	var m, s float64
+	if mins != "" {
+		if m, err = strconv.ParseFloat(mins, 64); err != nil {
+			return 0, err
+		}
+	}
+	if secs != "" {
+		if s, err = strconv.ParseFloat(secs, 64); err != nil {
+			return 0, err
+		}
+	}
+	if m >= 60 || s >= 60 {
+		return 0, fmt.Errorf("minutes and seconds must be below 60")
+	}
+	dec := d + m/60 + s/3600
+	if hemisphere == "S" || hemisphere == "W" {
+		dec = -dec
 	}
-	return fmt.Sprintf("%s %s %s %s", matchLat[2], matchLat[3], matchLat[4], matchLat[1]), fmt.Sprintf("%s %s %s %s", matchLon[2], matchLon[3], matchLon[4], matchLon[1]), nil
+	return dec, nil
 }
 
+// SetURLs changes the URLs the active nodes and rooms lists are read from (e.g. a caching proxy
+// or mirror). An empty URL keeps the current one. It should be called before the first Update.
+func SetURLs(nodesURL, roomsURL string) {
+	if nodesURL != "" {
+		activeNodesURL = nodesURL
+	}
+	if roomsURL != "" {
+		activeRoomsURL = roomsURL
+	}
+}
+
+// SetTLSConfig changes the TLS configuration used to read the lists, e.g. to trust the custom CA
+// of a mirror. The default configuration (strict verification) is used if nil. It should be
+// called before the first Update.
+func SetTLSConfig(cfg *tls.Config) {
+	httpTLSConfig = cfg
+	updateTransport()
+}
+
+// SetProxy changes the proxy the lists are read through. The proxy configured in the environment
+// (HTTP_PROXY, HTTPS_PROXY and NO_PROXY) is used if nil. It should be called before the first
+// Update.
+func SetProxy(proxy *url.URL) {
+	httpProxy = proxy
+	updateTransport()
+}
+
+// SetHeader changes the additional headers sent when reading the lists, e.g. the User-Agent. It
+// replaces all of them, so the header should include a User-Agent to keep identifying the
+// requests (DefaultUserAgent otherwise). It should be called before the first Update.
+func SetHeader(header http.Header) {
+	httpHeader = header
+}
+
+// updateTransport sets httpTransport according to httpTLSConfig and httpProxy.
+func updateTransport() {
+	if httpTLSConfig == nil && httpProxy == nil {
+		httpTransport = nil
+		return
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = httpTLSConfig
+	if httpProxy != nil {
+		t.Proxy = http.ProxyURL(httpProxy)
+	}
+	httpTransport = t
+}
+
+// SetHTTPTimeout changes how long to wait for a response from the Yaesu server before giving up.
+// It should be called before the first Update.
+func SetHTTPTimeout(d time.Duration) {
+	httpTimeout = d
+}
+
+// SetJitter changes the fraction by which the interval between two updates of AutoUpdate and
+// AutoUpdateAdaptive is randomly varied (e.g. 0.1 for ±10%), disabled if 0. It should be called
+// before starting to auto-update.
+func SetJitter(fraction float64) {
+	updateJitter = fraction
+}
+
+// Jittered returns d randomly varied by up to ±fraction of it, e.g. to spread polls over time.
+func Jittered(d time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return d
+	}
+	return d + time.Duration(float64(d)*fraction*(2*rand.Float64()-1))
+}
+
+// validators are the cache validators returned by the server for a previously read target.
+type validators struct {
+	etag         string
+	lastModified string
+}
+
+// read grabs the target and returns its content as a string. If the target did not change
+// since the last read, errNotModified is returned instead.
 func read(target string) (string, error) {
 	client := &http.Client{
-		Timeout: httpTimeout,
+		Timeout:   httpTimeout,
+		Transport: httpTransport,
+	}
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, values := range httpHeader {
+		req.Header[name] = values
+	}
+	validatorsMu.Lock()
+	v, ok := lastValidators[target]
+	validatorsMu.Unlock()
+	if ok {
+		if v.etag != "" {
+			req.Header.Set("If-None-Match", v.etag)
+		}
+		if v.lastModified != "" {
+			req.Header.Set("If-Modified-Since", v.lastModified)
+		}
 	}
-	response, err := client.Get(target)
+	response, err := client.Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer response.Body.Close()
 
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return "", errNotModified
+	default:
+		return "", fmt.Errorf("unexpected response from %q: %s", target, response.Status)
+	}
+
 	data, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return "", err
 	}
+
+	validatorsMu.Lock()
+	lastValidators[target] = validators{
+		etag:         response.Header.Get("ETag"),
+		lastModified: response.Header.Get("Last-Modified"),
+	}
+	validatorsMu.Unlock()
 	return string(data), nil
 }
 
@@ -79,8 +281,13 @@ func readAndDecodeRooms(verbose bool) (*data.ActiveRooms, error) {
 		return nil, err
 	}
 	if verbose {
-		log.Printf("V: Read %d bytes from %q", len(s), activeRoomsURL)
+		logging.Verbosef(logging.Fields{"target": activeRoomsURL, "bytes": len(s)}, "Read %d bytes from %q", len(s), activeRoomsURL)
 	}
+	return decodeRooms(s), nil
+}
+
+// decodeRooms parses the active rooms list s.
+func decodeRooms(s string) *data.ActiveRooms {
 	lines := strings.Split(s, "\n")
 
 	ar := &data.ActiveRooms{
@@ -112,7 +319,7 @@ func readAndDecodeRooms(verbose bool) (*data.ActiveRooms, error) {
 			ar.Rooms = append(ar.Rooms, r)
 		}
 	}
-	return ar, nil
+	return ar
 }
 
 func readAndDecodeNodes(verbose bool) (*data.ActiveNodes, error) {
@@ -121,8 +328,13 @@ func readAndDecodeNodes(verbose bool) (*data.ActiveNodes, error) {
 		return nil, err
 	}
 	if verbose {
-		log.Printf("V: Read %d bytes from %q", len(s), activeNodesURL)
+		logging.Verbosef(logging.Fields{"target": activeNodesURL, "bytes": len(s)}, "Read %d bytes from %q", len(s), activeNodesURL)
 	}
+	return decodeNodes(s, verbose), nil
+}
+
+// decodeNodes parses the active nodes list s.
+func decodeNodes(s string, verbose bool) *data.ActiveNodes {
 	lines := strings.Split(s, "\n")
 
 	an := &data.ActiveNodes{
@@ -141,8 +353,11 @@ func readAndDecodeNodes(verbose bool) (*data.ActiveNodes, error) {
 		if match := nodeRE.FindStringSubmatch(l); len(match) > 1 {
 			lat, lon, err := convertLatLon(html.UnescapeString(match[10]), html.UnescapeString(match[11]))
 			if err != nil {
-				lat = ""
-				lon = ""
+				if verbose {
+					logging.Verbosef(logging.Fields{"node": html.UnescapeString(match[1]), "error": err}, "Unable to parse the coordinates of node %q (ignoring): %v", html.UnescapeString(match[1]), err)
+				}
+				lat = 0
+				lon = 0
 			}
 			n := &data.Node{
 				ID:       html.UnescapeString(match[1]),
@@ -163,86 +378,460 @@ func readAndDecodeNodes(verbose bool) (*data.ActiveNodes, error) {
 			an.Nodes = append(an.Nodes, n)
 		}
 	}
-	return an, nil
+	return an
 }
 
 // Update reads a list of all active nodes and rooms from the Yaesu server and updates the cached list locally.
+// If a list did not change since the last update, the cached list is kept as is.
+// Both lists are updated independently: if one of them fails, the other one is still updated
+// and the returned error tells which one failed.
 func Update(verbose bool) error {
+	nodesErr := updateNodes(verbose)
+	roomsErr := updateRooms(verbose)
+	switch {
+	case nodesErr != nil && roomsErr != nil:
+		return fmt.Errorf("unable to update nodes: %v, unable to update rooms: %v", nodesErr, roomsErr)
+	case nodesErr != nil:
+		return fmt.Errorf("unable to update nodes (rooms updated): %v", nodesErr)
+	case roomsErr != nil:
+		return fmt.Errorf("unable to update rooms (nodes updated): %v", roomsErr)
+	}
+	return nil
+}
+
+// updateNodes reads the list of active nodes and replaces the cached list if it changed.
+func updateNodes(verbose bool) error {
 	an, err := readAndDecodeNodes(verbose)
-	if err != nil {
+	switch {
+	case errors.Is(err, errNotModified):
+		updatesTotal.Inc("nodes", "not_modified")
+		lastUpdateTimestamp.Set(float64(time.Now().Unix()), "nodes")
+		if verbose {
+			logging.Verbosef(logging.Fields{"list": "nodes"}, "Nodes list not modified since last update, keeping cached list")
+		}
+	case err != nil:
+		updatesTotal.Inc("nodes", "failure")
 		return err
+	default:
+		activeNodesMu.Lock()
+		activeNodes = an
+		activeNodesMu.Unlock()
+		updatesTotal.Inc("nodes", "success")
+		lastUpdateTimestamp.Set(float64(time.Now().Unix()), "nodes")
+		if err := saveCache(); err != nil {
+			logging.Errorf(logging.Fields{"error": err}, "Unable to save cache: %v", err)
+		}
 	}
+	return nil
+}
 
-	activeNodesMu.Lock()
-	activeNodes = an
-	activeNodesMu.Unlock()
-
+// updateRooms reads the list of active rooms and replaces the cached list if it changed.
+func updateRooms(verbose bool) error {
 	ar, err := readAndDecodeRooms(verbose)
-	if err != nil {
+	switch {
+	case errors.Is(err, errNotModified):
+		updatesTotal.Inc("rooms", "not_modified")
+		lastUpdateTimestamp.Set(float64(time.Now().Unix()), "rooms")
+		if verbose {
+			logging.Verbosef(logging.Fields{"list": "rooms"}, "Rooms list not modified since last update, keeping cached list")
+		}
+	case err != nil:
+		updatesTotal.Inc("rooms", "failure")
 		return err
+	default:
+		activeRoomsMu.Lock()
+		activeRooms = ar
+		activeRoomsMu.Unlock()
+		updatesTotal.Inc("rooms", "success")
+		lastUpdateTimestamp.Set(float64(time.Now().Unix()), "rooms")
+		if err := saveCache(); err != nil {
+			logging.Errorf(logging.Fields{"error": err}, "Unable to save cache: %v", err)
+		}
 	}
+	return nil
+}
 
-	activeRoomsMu.Lock()
-	activeRooms = ar
-	activeRoomsMu.Unlock()
+// AutoUpdate is a blocking function which updates the list of active nodes and rooms every d.
+// Note that intervals much below a minute risk being rate-limited by the Yaesu server.
+func AutoUpdate(d time.Duration, verbose bool) error {
+	return AutoUpdateAdaptive(d, d, verbose)
+}
 
-	return nil
+// Activity signals that there has been activity (e.g. events have been posted) for
+// AutoUpdateAdaptive to update the lists more often. It never blocks.
+func Activity() {
+	select {
+	case activity <- struct{}{}:
+	default:
+	}
 }
 
-// AutoUpdate is a blocking function which updates the list of active nodes and rooms every updateInterval.
-func AutoUpdate(verbose bool) error {
-	if err := Update(verbose); err != nil {
-		log.Printf("Unable to update nodes (temporarily?): %v", err)
+// AutoUpdateAdaptive updates the lists like AutoUpdate, but adapts the interval to the activity
+// signalled using Activity: the interval starts at min and doubles after each quiet interval up
+// to max. On activity, the lists are updated min after the last update and the interval is reset
+// to min.
+func AutoUpdateAdaptive(min, max time.Duration, verbose bool) error {
+	if min <= 0 {
+		return fmt.Errorf("update interval must be positive, got %s", min)
+	}
+	if max < min {
+		return fmt.Errorf("maximum update interval must not be below %s, got %s", min, max)
 	}
-	for _ = range time.Tick(updateInterval) {
+	d := min
+	for {
 		if err := Update(verbose); err != nil {
-			log.Printf("Unable to update nodes (temporarily?): %v", err)
-			continue // we don't want to abort in this case and retry later
+			// we don't want to abort in this case and retry later
+			logging.Errorf(logging.Fields{"error": err}, "Unable to update the Yaesu lists (temporarily?): %v", err)
+		}
+		last := time.Now()
+		timer := time.NewTimer(Jittered(d, updateJitter))
+		active := false
+	wait:
+		for {
+			select {
+			case <-timer.C:
+				break wait
+			case <-activity:
+				active = true
+				if d > min {
+					timer.Stop()
+					timer = time.NewTimer(time.Until(last.Add(min)))
+					d = min
+				}
+			}
+		}
+		if !active && d < max {
+			if d *= 2; d > max {
+				d = max
+			}
+			if verbose {
+				logging.Verbosef(logging.Fields{"interval": d.String()}, "No activity, updating the Yaesu lists again in %s", d)
+			}
 		}
 	}
-	return nil
 }
 
 // FindRoom searches through the list of active rooms for the given parameters and returns the
 // first room which matches. It returns nil if no room matched.
 func FindRoom(id, dtmfid, name string) *data.Room {
-	if activeRooms == nil {
+	rooms := FindRooms(id, dtmfid, name)
+	if len(rooms) == 0 {
 		return nil
 	}
+	return rooms[0]
+}
+
+// FindRooms searches through the list of active rooms for the given parameters and returns
+// all rooms which match. It returns nil if no room matched.
+// As the order of the Yaesu list is not guaranteed, the rooms are ordered by precedence: rooms
+// matching the ID first, then the name, then the DTMF ID. Ties are ordered by the lowest DTMF ID,
+// then the lowest ID (lexicographically).
+func FindRooms(id, dtmfid, name string) []*data.Room {
 	activeRoomsMu.RLock()
-	activeRoomsMu.RUnlock()
+	defer activeRoomsMu.RUnlock()
+	if activeRooms == nil {
+		return nil
+	}
+	var rooms []*data.Room
+	ranks := map[*data.Room]int{}
 	for _, r := range activeRooms.Rooms {
-		if id != "" && r.ID == id {
-			return r
-		}
-		if dtmfid != "" && r.DTMFID == dtmfid {
-			return r
-		}
-		if name != "" && r.Name == name {
-			return r
+		switch {
+		case SameNumber(id, r.ID):
+			ranks[r] = matchID
+		case name != "" && r.Name == name:
+			ranks[r] = matchName
+		case SameNumber(dtmfid, r.DTMFID):
+			ranks[r] = matchDTMFID
+		default:
+			continue
 		}
+		rooms = append(rooms, r)
 	}
-	return nil
+	sort.Slice(rooms, func(i, j int) bool {
+		a, b := rooms[i], rooms[j]
+		return precedes(ranks[a], a.DTMFID, a.ID, ranks[b], b.DTMFID, b.ID)
+	})
+	return rooms
 }
 
 // FindNode searches through the list of active nodes for the given parameters and returns the
 // first node which matches. It returns nil if no node matched.
 func FindNode(id, dtmfid, callsign string) *data.Node {
+	nodes := FindNodes(id, dtmfid, callsign)
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[0]
+}
+
+// FindNodes searches through the list of active nodes for the given parameters and returns
+// all nodes which match. It returns nil if no node matched.
+// As the order of the Yaesu list is not guaranteed, the nodes are ordered by precedence: nodes
+// matching the ID first, then the callsign, then the DTMF ID. Ties are ordered by the lowest DTMF
+// ID, then the lowest ID (lexicographically).
+func FindNodes(id, dtmfid, callsign string) []*data.Node {
+	activeNodesMu.RLock()
+	defer activeNodesMu.RUnlock()
 	if activeNodes == nil {
 		return nil
 	}
+	var nodes []*data.Node
+	ranks := map[*data.Node]int{}
+	for _, n := range activeNodes.Nodes {
+		switch {
+		case SameNumber(id, n.ID):
+			ranks[n] = matchID
+		case callsign != "" && n.Callsign == callsign:
+			ranks[n] = matchName
+		case SameNumber(dtmfid, n.DTMFID):
+			ranks[n] = matchDTMFID
+		default:
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		return precedes(ranks[a], a.DTMFID, a.ID, ranks[b], b.DTMFID, b.ID)
+	})
+	return nodes
+}
+
+// InLocation returns true if the location l is in the country and, unless state is empty, the
+// state. Both are compared case-insensitively.
+func InLocation(l *data.Location, country, state string) bool {
+	if l == nil || !strings.EqualFold(strings.TrimSpace(l.Country), country) {
+		return false
+	}
+	return state == "" || strings.EqualFold(strings.TrimSpace(l.State), state)
+}
+
+// FindNodesByLocation returns all active nodes located in the country and, unless state is
+// empty, the state (see InLocation). The nodes are ordered by the lowest DTMF ID, then the lowest
+// ID. It returns nil if no node matched.
+func FindNodesByLocation(country, state string) []*data.Node {
 	activeNodesMu.RLock()
-	activeNodesMu.RUnlock()
+	defer activeNodesMu.RUnlock()
+	if activeNodes == nil {
+		return nil
+	}
+	var nodes []*data.Node
 	for _, n := range activeNodes.Nodes {
-		if id != "" && n.ID == id {
-			return n
+		if InLocation(n.Location, country, state) {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		a, b := nodes[i], nodes[j]
+		return precedes(0, a.DTMFID, a.ID, 0, b.DTMFID, b.ID)
+	})
+	return nodes
+}
+
+// FindRoomsByLocation returns all active rooms located in the country and, unless state is
+// empty, the state (see InLocation). The rooms are ordered by the lowest DTMF ID, then the lowest
+// ID. It returns nil if no room matched.
+func FindRoomsByLocation(country, state string) []*data.Room {
+	activeRoomsMu.RLock()
+	defer activeRoomsMu.RUnlock()
+	if activeRooms == nil {
+		return nil
+	}
+	var rooms []*data.Room
+	for _, r := range activeRooms.Rooms {
+		if InLocation(r.Location, country, state) {
+			rooms = append(rooms, r)
 		}
-		if dtmfid != "" && n.DTMFID == dtmfid {
-			return n
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		a, b := rooms[i], rooms[j]
+		return precedes(0, a.DTMFID, a.ID, 0, b.DTMFID, b.ID)
+	})
+	return rooms
+}
+
+// precedes returns true if a match a is preferred over a match b: the lower rank wins, ties are
+// broken by the lowest DTMF ID, then the lowest ID.
+func precedes(aRank int, aDTMFID, aID string, bRank int, bDTMFID, bID string) bool {
+	if aRank != bRank {
+		return aRank < bRank
+	}
+	if aDTMFID != bDTMFID {
+		return aDTMFID < bDTMFID
+	}
+	return aID < bID
+}
+
+// normalizeNumber normalizes a node or room number (ID or DTMF ID) as rendered in the logs and
+// the Yaesu lists: spaces are removed and leading zeros stripped (e.g. " 05678" results in
+// "5678"). Numbers containing other characters than digits are only stripped of their spaces.
+func normalizeNumber(number string) string {
+	number = strings.Join(strings.Fields(number), "")
+	for _, c := range number {
+		if c < '0' || c > '9' {
+			return number
 		}
-		if callsign != "" && n.Callsign == callsign {
-			return n
+	}
+	if n := strings.TrimLeft(number, "0"); n != "" || number == "" {
+		return n
+	}
+	return "0"
+}
+
+// SameNumber returns true if a and b are the same node or room number (ID or DTMF ID) once
+// normalized, e.g. "05678" and "5678". Empty numbers never match.
+func SameNumber(a, b string) bool {
+	a = normalizeNumber(a)
+	return a != "" && a == normalizeNumber(b)
+}
+
+// normalizeCallsign normalizes the case of a callsign and strips common suffixes and prefixes
+// (e.g. "hb9tf/p", "HB9TF-ND" and "DL/HB9TF" all result in "HB9TF").
+func normalizeCallsign(callsign string) string {
+	callsign = strings.ToUpper(strings.Join(strings.Fields(callsign), ""))
+	// Keep the longest part around slashes which is the base callsign.
+	base := ""
+	for _, p := range strings.Split(callsign, "/") {
+		if len(p) > len(base) {
+			base = p
 		}
 	}
-	return nil
+	if i := strings.Index(base, "-"); i > 0 {
+		base = base[:i]
+	}
+	return base
+}
+
+// FindNodeByCallsign searches through the list of active nodes for the given callsign and
+// returns the best candidate. An exact match is preferred over a match of the normalized
+// callsigns (see normalizeCallsign). If fuzzy is true and neither matched, the node with the
+// closest normalized callsign containing (or contained in) the given one is returned.
+// Ties are broken by the lowest DTMF ID, then the lowest ID. It returns nil if no node matched.
+func FindNodeByCallsign(callsign string, fuzzy bool) *data.Node {
+	activeNodesMu.RLock()
+	defer activeNodesMu.RUnlock()
+	if activeNodes == nil || callsign == "" {
+		return nil
+	}
+	norm := normalizeCallsign(callsign)
+	var best *data.Node
+	bestRank := -1
+	for _, n := range activeNodes.Nodes {
+		// Exact matches rank 0, normalized matches 1 and fuzzy matches 2 plus the difference in length.
+		rank := -1
+		nNorm := normalizeCallsign(n.Callsign)
+		switch {
+		case n.Callsign == callsign:
+			rank = 0
+		case norm == "" || nNorm == "":
+		case nNorm == norm:
+			rank = 1
+		case fuzzy && (strings.Contains(nNorm, norm) || strings.Contains(norm, nNorm)):
+			diff := len(nNorm) - len(norm)
+			if diff < 0 {
+				diff = -diff
+			}
+			rank = 2 + diff
+		}
+		if rank < 0 {
+			continue
+		}
+		if best == nil || precedes(rank, n.DTMFID, n.ID, bestRank, best.DTMFID, best.ID) {
+			best = n
+			bestRank = rank
+		}
+	}
+	return best
+}
+
+// copyLocation returns a deep copy of the location.
+func copyLocation(l *data.Location) *data.Location {
+	if l == nil {
+		return nil
+	}
+	c := *l
+	return &c
+}
+
+// NodesAge returns how old the cached list of active nodes is, based on its LastUpdate.
+// It returns false if the list has not been populated yet.
+func NodesAge() (time.Duration, bool) {
+	activeNodesMu.RLock()
+	defer activeNodesMu.RUnlock()
+	if activeNodes == nil {
+		return 0, false
+	}
+	return time.Since(activeNodes.LastUpdate), true
+}
+
+// RoomsAge returns how old the cached list of active rooms is, based on its LastUpdate.
+// It returns false if the list has not been populated yet.
+func RoomsAge() (time.Duration, bool) {
+	activeRoomsMu.RLock()
+	defer activeRoomsMu.RUnlock()
+	if activeRooms == nil {
+		return 0, false
+	}
+	return time.Since(activeRooms.LastUpdate), true
+}
+
+// NodesReady returns true once the list of active nodes has been populated, either by an update
+// or from the cache. Until then, FindNodes cannot resolve any node.
+func NodesReady() bool {
+	activeNodesMu.RLock()
+	defer activeNodesMu.RUnlock()
+	return activeNodes != nil
+}
+
+// RoomsReady returns true once the list of active rooms has been populated, either by an update
+// or from the cache. Until then, FindRooms cannot resolve any room.
+func RoomsReady() bool {
+	activeRoomsMu.RLock()
+	defer activeRoomsMu.RUnlock()
+	return activeRooms != nil
+}
+
+// Ready returns true once both the lists of active nodes and rooms have been populated.
+func Ready() bool {
+	return NodesReady() && RoomsReady()
+}
+
+// ActiveNodesSnapshot returns a deep copy of the cached list of active nodes which can safely be
+// used (and modified) by the caller. It returns nil if the list has not been populated yet.
+func ActiveNodesSnapshot() *data.ActiveNodes {
+	activeNodesMu.RLock()
+	defer activeNodesMu.RUnlock()
+	if activeNodes == nil {
+		return nil
+	}
+	an := &data.ActiveNodes{
+		LastUpdate: activeNodes.LastUpdate,
+		Nodes:      make([]*data.Node, 0, len(activeNodes.Nodes)),
+	}
+	for _, n := range activeNodes.Nodes {
+		c := *n
+		c.Location = copyLocation(n.Location)
+		an.Nodes = append(an.Nodes, &c)
+	}
+	return an
+}
+
+// ActiveRoomsSnapshot returns a deep copy of the cached list of active rooms which can safely be
+// used (and modified) by the caller. It returns nil if the list has not been populated yet.
+func ActiveRoomsSnapshot() *data.ActiveRooms {
+	activeRoomsMu.RLock()
+	defer activeRoomsMu.RUnlock()
+	if activeRooms == nil {
+		return nil
+	}
+	ar := &data.ActiveRooms{
+		LastUpdate: activeRooms.LastUpdate,
+		Rooms:      make([]*data.Room, 0, len(activeRooms.Rooms)),
+	}
+	for _, r := range activeRooms.Rooms {
+		c := *r
+		c.Location = copyLocation(r.Location)
+		ar.Rooms = append(ar.Rooms, &c)
+	}
+	return ar
 }
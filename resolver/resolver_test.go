@@ -0,0 +1,265 @@
+package resolver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hb9tf/wireslacker/data"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// readTestdata returns the content of the file in testdata.
+func readTestdata(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestDecodeGolden parses the saved lists in testdata and compares the result with the golden
+// file in the format of the cache file.
+func TestDecodeGolden(t *testing.T) {
+	got, err := json.Marshal(&cache{
+		Nodes: decodeNodes(readTestdata(t, "nodes.html"), false),
+		Rooms: decodeRooms(readTestdata(t, "rooms.html")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := os.WriteFile("testdata/lists.golden.json", got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := readTestdata(t, "lists.golden.json")
+	if string(got) != strings.TrimSpace(want) {
+		t.Errorf("decoded lists = %s\nwant %s", got, want)
+	}
+}
+
+const (
+	testNodes = `<p class="update"><span>Update every 20 minutes</span> <span>15 Oct 2026 08:00:00 UTC</span></p>
+dataList[0] = {id:"12345", dtmf_id:"12345", call_sign:"HB9TF-ND", ana_dig:"Dig", city:"Zurich", state:"ZH", country:"Switzerland", freq:"145.375", sql:"88.5", lat:"N:47 22' 36", lon:"E:8 32' 24", comment:"Node of HB9TF"};
+dataList[1] = {id:"23456", dtmf_id:"23456", call_sign:"DL1XYZ", ana_dig:"Ana", city:"Berlin", state:"BE", country:"Germany", freq:"438.650", sql:"67.0", lat:"N:52 31' 12", lon:"E:13 24' 18", comment:"Tom &amp; Jerry"};
+`
+	testRooms = `<p class="update"><span>Update every 20 minutes</span> <span>15 Oct 2026 08:00:00 UTC</span></p>
+dataList[0] = {id:"28000", dtmp:"28000", act:"12", room_name:"CQ-ZURICH", city:"Zurich", state:"ZH", country:"Switzerland", comment:"Swiss room"};
+`
+)
+
+// serveLists serves testNodes and testRooms in place of the Yaesu server until the test ends.
+func serveLists(t *testing.T) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No cache validators, so each update replaces the lists.
+		if strings.Contains(r.URL.Path, "room") {
+			io.WriteString(w, testRooms)
+			return
+		}
+		io.WriteString(w, testNodes)
+	}))
+	t.Cleanup(srv.Close)
+	orig := http.DefaultTransport
+	http.DefaultTransport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, srv.Listener.Addr().String())
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	t.Cleanup(func() { http.DefaultTransport = orig })
+}
+
+// TestUpdateConcurrentFind updates the lists while looking up nodes and rooms concurrently, to
+// be run with -race.
+func TestUpdateConcurrentFind(t *testing.T) {
+	serveLists(t)
+	if err := Update(false); err != nil {
+		t.Fatalf("Update() failed: %v", err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			if err := Update(false); err != nil {
+				t.Errorf("Update() failed: %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if n := FindNode("", "23456", ""); n == nil || n.Callsign != "DL1XYZ" {
+					t.Errorf("FindNode(23456) = %v, want DL1XYZ", n)
+					return
+				}
+				if r := FindRoom("28000", "", ""); r == nil || r.Name != "CQ-ZURICH" {
+					t.Errorf("FindRoom(28000) = %v, want CQ-ZURICH", r)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConvertLatLon(t *testing.T) {
+	tests := []struct {
+		lat, lon         string
+		wantLat, wantLon float64
+		wantErr          bool
+	}{
+		{lat: "N:47 22' 36", lon: "E:8 32' 24", wantLat: 47.376667, wantLon: 8.54},
+		{lat: "S:33 52' 4.5", lon: "W:151 12' 30.25", wantLat: -33.867917, wantLon: -151.208403},
+		{lat: "N:47 22'", lon: "E:8 32'", wantLat: 47.366667, wantLon: 8.533333},
+		{lat: "N:47 22.5'", lon: "E:8 32.25'", wantLat: 47.375, wantLon: 8.5375},
+		{lat: "N:47", lon: "W:8", wantLat: 47, wantLon: -8},
+		{lat: "N:47.5", lon: "E:8.25", wantLat: 47.5, wantLon: 8.25},
+		{lat: `N:47° 22' 36"`, lon: `E:8° 32' 24"`, wantLat: 47.376667, wantLon: 8.54},
+		{lat: "N 47 22 36", lon: "E 8 32 24", wantLat: 47.376667, wantLon: 8.54},
+		{lat: "", lon: "", wantErr: true},
+		{lat: "47 22' 36", lon: "E:8 32' 24", wantErr: true},
+		{lat: "N:47 22' 36", lon: "8 32' 24", wantErr: true},
+		{lat: "N:47 61' 0", lon: "E:8 32' 24", wantErr: true},
+		{lat: "N:47 22' 60", lon: "E:8 32' 24", wantErr: true},
+	}
+	for _, tt := range tests {
+		lat, lon, err := convertLatLon(tt.lat, tt.lon)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("convertLatLon(%q, %q) = %f, %f, want an error", tt.lat, tt.lon, lat, lon)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("convertLatLon(%q, %q) failed: %v", tt.lat, tt.lon, err)
+			continue
+		}
+		if math.Abs(lat-tt.wantLat) > 1e-6 || math.Abs(lon-tt.wantLon) > 1e-6 {
+			t.Errorf("convertLatLon(%q, %q) = %f, %f, want %f, %f", tt.lat, tt.lon, lat, lon, tt.wantLat, tt.wantLon)
+		}
+	}
+}
+
+func TestNormalizeNumber(t *testing.T) {
+	tests := []struct {
+		number, want string
+	}{
+		{"5678", "5678"},
+		{"05678", "5678"},
+		{" 5678 ", "5678"},
+		{" 05 678", "5678"},
+		{"000", "0"},
+		{"", ""},
+		{"  ", ""},
+		{"CQ-ZURICH", "CQ-ZURICH"},
+		{"0AB 12", "0AB12"},
+	}
+	for _, tt := range tests {
+		if got := normalizeNumber(tt.number); got != tt.want {
+			t.Errorf("normalizeNumber(%q) = %q, want %q", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestSameNumber(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"05678", "5678", true},
+		{" 5678 ", "5678", true},
+		{"005678", " 05678", true},
+		{"5678", "56780", false},
+		{"0", "000", true},
+		{"", "", false},
+		{" ", "0", false},
+	}
+	for _, tt := range tests {
+		if got := SameNumber(tt.a, tt.b); got != tt.want {
+			t.Errorf("SameNumber(%q, %q) = %t, want %t", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// TestDecodeQuotedComments ensures that a comment containing quotes, commas and the markers of
+// other fields does not corrupt the fields of its node or room.
+func TestDecodeQuotedComments(t *testing.T) {
+	an := decodeNodes(readTestdata(t, "nodes.html"), false)
+	var node *data.Node
+	for _, n := range an.Nodes {
+		if n.ID == "45678" {
+			node = n
+		}
+	}
+	if node == nil {
+		t.Fatalf("node 45678 not found in %v", an.Nodes)
+	}
+	if l := node.Location; l.City != "Lyon" || l.State != "ARA" || l.Country != "France" {
+		t.Errorf("node location = %q, %q, %q, want Lyon, ARA, France", l.City, l.State, l.Country)
+	}
+	if want := `Moved from city:"Paris", state:"IDF", country:"France", freq:"", sql:"", lat:"", lon:"", comment:"old node"`; node.Comment != want {
+		t.Errorf("node comment = %q, want %q", node.Comment, want)
+	}
+
+	ar := decodeRooms(readTestdata(t, "rooms.html"))
+	var room *data.Room
+	for _, r := range ar.Rooms {
+		if r.ID == "20880" {
+			room = r
+		}
+	}
+	if room == nil {
+		t.Fatalf("room 20880 not found in %v", ar.Rooms)
+	}
+	if l := room.Location; room.Act != "3" || l.City != "Paris" || l.State != "IDF" || l.Country != "France" {
+		t.Errorf("room activity and location = %q, %q, %q, %q, want 3, Paris, IDF, France", room.Act, l.City, l.State, l.Country)
+	}
+	if want := `Join "us", city:"all", state:"", country:"all", comment:"99"`; room.Comment != want {
+		t.Errorf("room comment = %q, want %q", room.Comment, want)
+	}
+}
+
+// TestReadUserAgent ensures the lists are read with the default User-Agent unless changed.
+func TestReadUserAgent(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.UserAgent()
+	}))
+	defer srv.Close()
+
+	if _, err := read(srv.URL); err != nil {
+		t.Fatalf("read() failed: %v", err)
+	}
+	if got != DefaultUserAgent {
+		t.Errorf("User-Agent = %q, want %q", got, DefaultUserAgent)
+	}
+
+	orig := httpHeader
+	defer SetHeader(orig)
+	SetHeader(http.Header{"User-Agent": {"wireslacker/1.0"}})
+	if _, err := read(srv.URL); err != nil {
+		t.Fatalf("read() failed: %v", err)
+	}
+	if want := "wireslacker/1.0"; got != want {
+		t.Errorf("User-Agent = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version information, injected at build time using -ldflags, e.g.
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo returns the version, commit and build date. If the commit or build date have not
+// been injected, they are taken from the VCS information embedded by the go tool (if any).
+func buildInfo() (string, string, string) {
+	c, d := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value
+			case s.Key == "vcs.time" && d == "":
+				d = s.Value
+			}
+		}
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if d == "" {
+		d = "unknown"
+	}
+	return version, c, d
+}
+
+// versionString renders the version information in a single line.
+func versionString() string {
+	v, c, d := buildInfo()
+	return fmt.Sprintf("wireslacker %s (commit %s, built %s, %s)", v, c, d, runtime.Version())
+}
@@ -1,101 +1,414 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
-	"strings"
-	"sync"
+	"os/signal"
+	"regexp"
+	"syscall"
 	"time"
 
 	"github.com/hb9tf/wireslacker/data"
+	"github.com/hb9tf/wireslacker/logging"
+	"github.com/hb9tf/wireslacker/metrics"
+	"github.com/hb9tf/wireslacker/pipeline"
 	"github.com/hb9tf/wireslacker/processor"
 	"github.com/hb9tf/wireslacker/reader"
 	"github.com/hb9tf/wireslacker/resolver"
 )
 
 var (
-	targets      = flag.String("targets", "", "coma separated paths or URLs to the log files")
-	readInterval = flag.Duration("readInterval", 10*time.Second, "interval in which to read the provided logs")
-	webHook      = flag.String("webhook", "", "webhook to use to post to slack")
-	location     = flag.String("location", "Local", "location of the Wires-X server - see https://golang.org/pkg/time/#Location for details")
-	verbose      = flag.Bool("v", false, "log more detailed messages")
-	dry          = flag.Bool("dry", false, "do not post to slack channel if true")
+	showVersion       = flag.Bool("version", false, "print the version and exit")
+	configFile        = flag.String("config", "", "path to a JSON config file - flags override its values")
+	targets           = flag.String("targets", "", "coma separated paths or URLs to the log files, each optionally suffixed by its own read interval (e.g. target:5s)")
+	readInterval      = flag.Duration("readInterval", 10*time.Second, "default interval in which to read the provided logs")
+	readTimeout       = flag.Duration("readTimeout", 5*time.Second, "how long to wait for an HTTP/S log target to respond")
+	jitter            = flag.Float64("jitter", 0.1, "fraction by which the intervals of the polls and the Yaesu list updates are randomly varied to spread the load (e.g. 0.1 for ±10%), disabled if 0")
+	httpUser          = flag.String("httpUser", "", "username for HTTP basic auth on HTTP/S log targets")
+	tlsCA             = flag.String("tlsCA", "", "path to a PEM file of additional CAs to trust for HTTPS log targets, the Yaesu lists and the MQTT broker (e.g. for self-signed certificates)")
+	tlsInsecure       = flag.Bool("tlsInsecure", false, "do not verify TLS certificates of HTTPS log targets, the Yaesu lists and the MQTT broker - insecure, prefer -tlsCA")
+	httpPassword      = flag.String("httpPassword", "", "password for HTTP basic auth on HTTP/S log targets")
+	proxy             = flag.String("proxy", "", "URL of the proxy for all outbound HTTP requests (e.g. http://host:3128), the one configured in the environment (HTTPS_PROXY, HTTP_PROXY) if empty")
+	yaesuInterval     = flag.Duration("yaesuInterval", resolver.DefaultUpdateInterval, "interval in which to refresh the Yaesu active nodes and rooms lists - values much below a minute risk being rate-limited")
+	yaesuMaxInterval  = flag.Duration("yaesuMaxInterval", 0, "interval up to which the refresh of the Yaesu lists is slowed down while no events are posted, starting at -yaesuInterval, disabled if 0")
+	minReadInterval   = flag.Duration("minReadInterval", 2*time.Second, "shortest read interval accepted for the targets, to not overload the node PCs")
+	minYaesuInterval  = flag.Duration("minYaesuInterval", time.Minute, "shortest interval accepted to refresh the Yaesu lists, to not overload the Yaesu server")
+	yaesuNodesURL     = flag.String("yaesuNodesURL", resolver.DefaultNodesURL, "URL of the Yaesu active nodes list")
+	yaesuRoomsURL     = flag.String("yaesuRoomsURL", resolver.DefaultRoomsURL, "URL of the Yaesu active rooms list")
+	yaesuCache        = flag.String("yaesuCache", "", "path to a file to cache the Yaesu active nodes and rooms lists across restarts")
+	yaesuTimeout      = flag.Duration("yaesuTimeout", 30*time.Second, "how long to wait for the Yaesu active nodes and rooms lists to respond")
+	yaesuStaleAfter   = flag.Duration("yaesuStaleAfter", 2*time.Hour, "note in enriched messages that the Yaesu lists may be stale if not updated for this long, disabled if 0")
+	webHook           = flag.String("webhook", "", "webhook to use to post to slack")
+	slackToken        = flag.String("slackToken", "", "slack bot token to post using the Web API instead of the webhook (required for threading)")
+	slackChannel      = flag.String("slackChannel", "", "slack channel to post to using the bot token")
+	slackUsername     = flag.String("slackUsername", "", "name to post slack messages with (e.g. \"Wires-X Bot\"), the default of the webhook or bot if empty")
+	slackIcon         = flag.String("slackIcon", "", "emoji (e.g. :radio:) or URL of an image to post slack messages with, the default of the webhook or bot if empty")
+	slackRate         = flag.Float64("slackRate", 1, "maximum average number of slack posts per second, further posts are delayed, unlimited if 0")
+	slackBurst        = flag.Int("slackBurst", 5, "maximum number of slack posts sent in a burst before -slackRate applies")
+	postWorkers       = flag.Int("postWorkers", 4, "number of nodes or rooms whose events are posted concurrently so a slow post does not hold back the others, the events of each node are posted in order")
+	telegramToken     = flag.String("telegramToken", "", "telegram bot token to post to a telegram chat instead of slack")
+	telegramChat      = flag.String("telegramChat", "", "telegram chat ID to post to using the bot token")
+	mqttBroker        = flag.String("mqttBroker", "", "MQTT broker to publish to instead of slack (e.g. tcp://host:1883 or ssl://host:8883)")
+	mqttTopic         = flag.String("mqttTopic", "wireslacker", "prefix of the MQTT topics, events are published to <prefix>/<node ID>/event")
+	mqttUser          = flag.String("mqttUser", "", "user to connect to the MQTT broker")
+	mqttPassword      = flag.String("mqttPassword", "", "password to connect to the MQTT broker")
+	webhookSigningKey = flag.String("webhookSigningKey", "", "key to sign the body of each post to the webhook with (HMAC-SHA256 in the "+processor.WebhookSignatureHeader+" header), e.g. for a custom receiver")
+	webhookHosts      = flag.String("webhookHosts", "", "coma separated hosts accepted for the webhook, defaults to the ones of the backend (e.g. hooks.slack.com)")
+	backend           = flag.String("backend", "", "coma separated backends to post to (slack, discord, telegram or mqtt), all configured ones if empty")
+	location          = flag.String("location", "Local", "location of the Wires-X server - see https://golang.org/pkg/time/#Location for details")
+	timeFormat        = flag.String("timeFormat", "", "format of the event timestamps - see https://golang.org/pkg/time/#Parse, known formats are tried if empty or not matching")
+	verbose           = flag.Bool("v", false, "log more detailed messages")
+	logFormat         = flag.String("logformat", logging.FormatText, "format of the log messages (text or json)")
+	dry               = flag.Bool("dry", false, "do not post to slack channel if true")
+	since             = flag.Duration("since", 0, "on the first run (see -state), also post the events of each target which happened this long before the start (e.g. 1h), at most the last 20")
+	once              = flag.Bool("once", false, "read each target once, post all its events not posted before (see -state) and exit, e.g. to process a saved log")
+	batchWindow       = flag.Duration("batchWindow", 0, "post events of the same log which happened within this window as a single message, disabled if 0")
+	dedupWindow       = flag.Duration("dedupWindow", 0, "post identical events (same node, time and message) read again or from different targets within this window only once, disabled if 0")
+	alertAfter        = flag.Duration("alertAfter", 0, "post an alert if a target could not be polled for this long (e.g. 30m) and once it recovers, disabled if 0")
+	noDefaultFilters  = flag.Bool("noDefaultFilters", false, "disable the built-in filters of noisy events")
+	includeCategories = flag.String("include", "", "coma separated event categories to post exclusively (see README)")
+	excludeCategories = flag.String("exclude", "", "coma separated event categories not to post (see README)")
+	allowNodes        = flag.String("allowNodes", "", "coma separated callsigns, IDs or DTMF IDs of the nodes to post events of exclusively (see README)")
+	allowLocations    = flag.String("allowLocations", "", "coma separated countries or country/state pairs of the nodes to post events of exclusively (see README)")
+	allowUnresolved   = flag.Bool("allowUnresolved", false, "with -allowNodes or -allowLocations, also post events whose node cannot be resolved")
+	colors            = flag.String("colors", "", "coma separated category:color pairs overriding the color of the posted events (e.g. disconnected:danger)")
+	mapThumbURL       = flag.String("mapThumbURL", "", "URL template of a static map image shown as thumbnail of enriched messages, {lat} and {lon} are replaced by the coordinates of the node")
+	includeRaw        = flag.Bool("includeRaw", false, "append the raw log line of each posted event as a code block, e.g. to debug the parsing")
+	nodeURL           = flag.String("nodeURL", "", "URL template of the page of a node linked from the title of enriched messages, {id}, {dtmf} and {callsign} are replaced by the ones of the node")
+	maxTextLength     = flag.Int("maxTextLength", 3000, "truncate the text of enriched messages (e.g. long node comments) to this many characters, unlimited if 0")
+	connectionEvents  = flag.Bool("connectionEvents", false, "post an event whenever a node connects to another node or room, as shown on its log page")
+	thread            = flag.Bool("thread", false, "post all events of the same node or room in a thread (requires -slackToken)")
+	statePath         = flag.String("state", "", "path to a file to persist the last posted event per target across restarts")
+	metricsAddr       = flag.String("metrics", "", "address to serve Prometheus metrics on (e.g. :9100), disabled if empty")
+	healthAddr        = flag.String("healthAddr", "", "address to serve the /healthz and /readyz health checks on (e.g. :8080), disabled if empty")
+	recentEvents      = flag.Int("recentEvents", 100, "number of recent events served as JSON on /events of the health checks (or metrics) server, disabled if 0")
+	export            = flag.String("export", "", "export each parsed log in this format (jsonl), in addition to posting if a backend is configured")
+	exportPath        = flag.String("exportPath", "-", "file to append the exported logs to, - for stdout")
+	archive           = flag.String("archive", "", "file to append each new event (with its log and enrichment) to as JSON Lines for archival, independently of posting")
+	archiveMaxSize    = flag.Int("archiveMaxSize", 100, "size in MB above which the archive file is rotated, disabled if 0")
+	archiveDaily      = flag.Bool("archiveDaily", false, "rotate the archive file each day")
+
+	filters        stringList
+	filterRegexps  stringList
+	headers        stringList
+	webhookHeaders stringList
 )
 
-// read uses the provided reader to read the log from target and sends the data.Log to the logChan.
-func read(reader reader.Log, target string, verbose bool, logChan chan *data.Log) error {
-	if verbose {
-		log.Printf("V: Polling log %q", target)
-	}
-	evtLog, err := reader.Read()
-	if err != nil {
-		return err
+// newNotifiers creates the notifiers for all configured backends.
+func newNotifiers(cfg *Config) ([]processor.Notifier, error) {
+	var notifiers []processor.Notifier
+	for _, b := range cfg.Backends {
+		n, err := newNotifier(cfg, b)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create the %s notifier: %v", b, err)
+		}
+		notifiers = append(notifiers, n)
 	}
-	logChan <- evtLog
-	return nil
+	return notifiers, nil
 }
 
-// readEvery reads the Wires-X log from the provided target every d and sends the
-// parsed log to the provided logChan for further processing.
-// Note that only non-recoverable errors should return. Retryable ones should log only.
-func readEvery(d time.Duration, target string, verbose bool, logChan chan *data.Log, loc *time.Location) error {
-	reader, err := reader.New(target, loc, verbose)
-	if err != nil {
-		return fmt.Errorf("unable to get reader: %v", err)
+// newRoutes creates the notifiers of all routes, keyed by the log source or ID they match.
+func newRoutes(cfg *Config) (map[string][]processor.Notifier, error) {
+	routes := map[string][]processor.Notifier{}
+	for _, r := range cfg.Routes {
+		rc := *cfg
+		rc.Backends = r.Backends
+		rc.Webhook = r.Webhook
+		rc.SlackChannel = r.SlackChannel
+		rc.TelegramChat = r.TelegramChat
+		if r.SlackChannel == "" {
+			rc.SlackToken = "" // post to the webhook of the route
+		}
+		notifiers, err := newNotifiers(&rc)
+		if err != nil {
+			return nil, err
+		}
+		routes[reader.Source(r.Match)] = notifiers
 	}
+	return routes, nil
+}
 
-	if err := read(reader, target, verbose, logChan); err != nil {
-		log.Printf("Unable to poll log %q (temporarily?): %v", target, err) // we don't want to abort in this case and retry later
-	}
-	for _ = range time.Tick(d) {
-		if err := read(reader, target, verbose, logChan); err != nil {
-			log.Printf("Unable to poll log %q (temporarily?): %v", target, err)
-			continue // we don't want to abort in this case and retry later
+// newNotifier creates the notifier for the backend.
+func newNotifier(cfg *Config, backend string) (processor.Notifier, error) {
+	switch backend {
+	case backendDiscord:
+		return processor.NewDiscord(cfg.Webhook, cfg.Dry, cfg.Verbose), nil
+	case backendTelegram:
+		return processor.NewTelegram(cfg.TelegramToken, cfg.TelegramChat, cfg.Dry, cfg.Verbose), nil
+	case backendMQTT:
+		tlsConfig, err := newTLSConfig(cfg.TLSCA, cfg.TLSInsecure)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %v", err)
 		}
+		return processor.NewMQTT(cfg.MQTTBroker, cfg.MQTTTopic, cfg.MQTTUser, cfg.MQTTPassword, tlsConfig, cfg.Dry, cfg.Verbose)
+	default:
+		var s *processor.Slacker
+		if cfg.SlackToken != "" {
+			s = processor.NewSlackBot(cfg.SlackToken, cfg.SlackChannel, processor.NewRateLimiter(cfg.SlackRate, cfg.SlackBurst), cfg.Dry, cfg.Verbose)
+		} else {
+			s = processor.NewSlacker(cfg.Webhook, processor.NewRateLimiter(cfg.SlackRate, cfg.SlackBurst), cfg.Dry, cfg.Verbose)
+			header := http.Header{}
+			for name, value := range cfg.WebhookHeaders {
+				header.Set(name, value)
+			}
+			s.SetWebhookAuth(header, cfg.WebhookSigningKey)
+		}
+		if slackEmojiRE.MatchString(cfg.SlackIcon) {
+			s.SetIdentity(cfg.SlackUsername, cfg.SlackIcon, "")
+		} else {
+			s.SetIdentity(cfg.SlackUsername, "", cfg.SlackIcon)
+		}
+		return s, nil
 	}
-	return nil
+}
+
+// fail prints the formatted error message to stderr and exits with code (see exitFailure).
+func fail(code int, format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(code)
+}
+
+func init() {
+	flag.Var(&filters, "filter", "do not post events containing this string (can be repeated)")
+	flag.Var(&filterRegexps, "filterRegexp", "do not post events matching this regexp (can be repeated)")
+	flag.Var(&webhookHeaders, "webhookHeader", "additional header to send with each post to the webhook as \"Name: value\", e.g. a shared secret for a custom receiver (can be repeated)")
+	flag.Var(&headers, "header", "additional header to send to HTTP/S log targets and the Yaesu lists as \"Name: value\", e.g. to override the User-Agent (can be repeated)")
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lookup":
+			os.Exit(lookup(os.Args[2:]))
+		case "dump-nodes", "dump-rooms":
+			os.Exit(dump(os.Args[1], os.Args[2:]))
+		}
+	}
 	flag.Parse()
+	if *showVersion {
+		fmt.Println(versionString())
+		os.Exit(0)
+	}
+
+	cfg, err := getConfig()
+	if err != nil {
+		fail(exitCode(err), "%v", err)
+	}
 
-	// Ensure necessary flags have been provided.
-	if *webHook == "" {
-		fmt.Println("provide a valid webhook URL for slack")
-		os.Exit(1)
+	if err := logging.SetFormat(cfg.LogFormat); err != nil {
+		fail(exitConfig, "%v", err)
 	}
-	if *targets == "" {
-		fmt.Println("provide at least one target")
-		os.Exit(1)
+	v, c, d := buildInfo()
+	logging.Infof(logging.Fields{"version": v, "commit": c, "build_date": d}, "Starting %s", versionString())
+	if cfg.Verbose {
+		logSummary(cfg)
 	}
 
-	loc, err := time.LoadLocation(*location)
-	if err != nil {
-		fmt.Printf("unable to parse provided location %q: %v\n", *location, err)
-		os.Exit(1)
+	// Expose metrics and health checks if requested, sharing the server if on the same address.
+	muxes := map[string]*http.ServeMux{}
+	if cfg.MetricsAddr != "" {
+		muxes[cfg.MetricsAddr] = http.NewServeMux()
+		muxes[cfg.MetricsAddr].Handle("/metrics", metrics.Handler())
+		logging.Infof(logging.Fields{"addr": cfg.MetricsAddr}, "Serving metrics on %q", cfg.MetricsAddr)
+	}
+	if cfg.HealthAddr != "" {
+		for _, t := range cfg.Targets {
+			readiness.expect(reader.Redact(t.Target))
+		}
+		if muxes[cfg.HealthAddr] == nil {
+			muxes[cfg.HealthAddr] = http.NewServeMux()
+		}
+		muxes[cfg.HealthAddr].HandleFunc("/healthz", readiness.handleHealthz)
+		muxes[cfg.HealthAddr].HandleFunc("/readyz", readiness.handleReadyz)
+		logging.Infof(logging.Fields{"addr": cfg.HealthAddr}, "Serving health checks on %q", cfg.HealthAddr)
+	}
+	// Serve the recent events next to the health checks, or the metrics if there are none.
+	var recent *processor.Recent
+	if addr := cfg.HealthAddr; cfg.RecentEvents > 0 && (addr != "" || cfg.MetricsAddr != "") {
+		if addr == "" {
+			addr = cfg.MetricsAddr
+		}
+		recent = processor.NewRecent(cfg.RecentEvents)
+		muxes[addr].Handle("/events", recent)
+		logging.Infof(logging.Fields{"addr": addr}, "Serving the last %d events on %q", cfg.RecentEvents, addr)
+	}
+	for addr, mux := range muxes {
+		go func(addr string, mux *http.ServeMux) {
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				logging.Errorf(logging.Fields{"addr": addr, "error": err}, "Unable to serve on %q: %v", addr, err)
+			}
+		}(addr, mux)
 	}
 
+	// Cancel all in-flight polls on shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Route all outbound HTTP requests through the proxy, if any.
+	proxyURL, _ := newProxy(cfg.Proxy) // validated in getConfig
+	resolver.SetProxy(proxyURL)
+	processor.SetProxy(proxyURL)
+	// Identify wireslacker to the log targets and the Yaesu server.
+	header := http.Header{"User-Agent": {"wireslacker/" + version}}
+	for name, value := range cfg.Headers {
+		header.Set(name, value)
+	}
+	resolver.SetHeader(header)
+
 	// Start auto-updating of active nodes cache.
-	go resolver.AutoUpdate(*verbose)
+	resolver.SetHTTPTimeout(time.Duration(cfg.YaesuTimeout))
+	resolver.SetJitter(cfg.Jitter)
+	yaesuTLS, err := newTLSConfig(cfg.TLSCA, cfg.TLSInsecure)
+	if err != nil {
+		fail(exitConfig, "invalid TLS configuration: %v", err)
+	}
+	if cfg.TLSInsecure {
+		logging.Errorf(nil, "WARNING: TLS certificate verification is disabled for the Yaesu lists and the MQTT broker, the connection is not secure")
+	}
+	resolver.SetTLSConfig(yaesuTLS)
+	resolver.SetURLs(cfg.YaesuNodesURL, cfg.YaesuRoomsURL)
+	resolver.SetCacheFile(cfg.YaesuCache)
+	if err := resolver.LoadCache(cfg.Verbose); err != nil {
+		logging.Errorf(logging.Fields{"path": cfg.YaesuCache, "error": err}, "Unable to load cached nodes and rooms from %q (ignoring): %v", cfg.YaesuCache, err)
+	}
+	if cfg.Once {
+		// Update the lists before reading so all events are enriched.
+		if err := resolver.Update(cfg.Verbose); err != nil {
+			logging.Errorf(logging.Fields{"error": err}, "Unable to update nodes and rooms: %v", err)
+		}
+	} else {
+		if cfg.YaesuInterval < Duration(recommendedYaesuInterval) {
+			logging.Errorf(logging.Fields{"interval": time.Duration(cfg.YaesuInterval).String()}, "WARNING: the Yaesu lists are refreshed every %s, intervals below %s put unnecessary load on the Yaesu server", time.Duration(cfg.YaesuInterval), recommendedYaesuInterval)
+		}
+		go func() {
+			maxInterval := cfg.YaesuMaxInterval
+			if maxInterval == 0 {
+				maxInterval = cfg.YaesuInterval
+			}
+			if err := resolver.AutoUpdateAdaptive(time.Duration(cfg.YaesuInterval), time.Duration(maxInterval), cfg.Verbose); err != nil {
+				logging.Errorf(logging.Fields{"error": err}, "Unable to auto-update nodes and rooms (stopping): %v", err)
+			}
+		}()
+	}
 
-	// Create log channel and start processing of incoming data.
-	logChan := make(chan *data.Log)
-	go processor.Run(logChan, processor.NewSlacker(*webHook, *dry, *verbose), *verbose)
+	state, err := processor.LoadState(cfg.StatePath)
+	if err != nil {
+		fail(exitFailure, "unable to load state from %q: %v", cfg.StatePath, err)
+	}
 
-	// Start a reader for each target which has been provided.
-	var wg sync.WaitGroup
-	for _, target := range strings.Split(*targets, ",") {
-		wg.Add(1)
-		go func(target string) {
-			defer wg.Done()
-			log.Printf("Start polling %q\n", target)
-			if err := readEvery(*readInterval, target, *verbose, logChan, loc); err != nil {
-				log.Printf("Unable to poll log %q (stopping): %v", target, err)
-				return
+	// Configure the processing of incoming data.
+	procCfg := processor.Config{
+		BatchWindow:       time.Duration(cfg.BatchWindow),
+		DedupWindow:       time.Duration(cfg.DedupWindow),
+		Filters:           cfg.Filters,
+		NoDefaultFilters:  cfg.NoDefaultFilters,
+		IncludeCategories: cfg.IncludeCategories,
+		ExcludeCategories: cfg.ExcludeCategories,
+		AllowNodes:        cfg.AllowNodes,
+		AllowLocations:    cfg.AllowLocations,
+		AllowUnresolved:   cfg.AllowUnresolved,
+		Thread:            cfg.Thread,
+		Backfill:          cfg.Once,
+		Since:             time.Duration(cfg.Since),
+		ConnectionEvents:  cfg.ConnectionEvents,
+		PostWorkers:       cfg.PostWorkers,
+		Recent:            recent,
+		StaleAfter:        time.Duration(cfg.YaesuStaleAfter),
+		MapThumbURL:       cfg.MapThumbURL,
+		NodeURL:           cfg.NodeURL,
+		IncludeRaw:        cfg.IncludeRaw,
+		MaxTextLength:     cfg.MaxTextLength,
+		Colors:            map[data.Category]string{},
+	}
+	if cfg.Thread && cfg.SlackToken == "" {
+		logging.Errorf(nil, "Threading requires a slack bot token (-slackToken), posting all messages top-level")
+	}
+	for _, t := range cfg.Targets {
+		if t.Channel != "" {
+			if procCfg.Channels == nil {
+				procCfg.Channels = map[string]string{}
 			}
-		}(target)
+			procCfg.Channels[reader.Source(t.Target)] = t.Channel
+		}
+	}
+	for c, color := range cfg.Colors {
+		procCfg.Colors[data.Category(c)] = color
+	}
+	for _, f := range cfg.FilterRegexps {
+		procCfg.FilterRegexps = append(procCfg.FilterRegexps, regexp.MustCompile(f)) // validated in getConfig
+	}
+	notifiers, err := newNotifiers(cfg)
+	if err != nil {
+		fail(exitConfig, "%v", err)
+	}
+	if procCfg.Routes, err = newRoutes(cfg); err != nil {
+		fail(exitConfig, "%v", err)
+	}
+	if cfg.Export != "" {
+		w := os.Stdout
+		if cfg.ExportPath != "-" {
+			if w, err = os.OpenFile(cfg.ExportPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+				fail(exitFailure, "unable to open export file %q: %v", cfg.ExportPath, err)
+			}
+			defer w.Close()
+		}
+		procCfg.Exporter = processor.NewExporter(w)
+	}
+	if cfg.Archive != "" {
+		a, err := processor.NewArchive(cfg.Archive, int64(cfg.ArchiveMaxSize)<<20, cfg.ArchiveDaily)
+		if err != nil {
+			fail(exitFailure, "unable to open archive %q: %v", cfg.Archive, err)
+		}
+		defer a.Close()
+		procCfg.Archive = a
+	}
+	// Start a reader for each target which has been provided.
+	pipeCfg := pipeline.Config{
+		Notifiers:  notifiers,
+		Processor:  procCfg,
+		State:      state,
+		AlertAfter: time.Duration(cfg.AlertAfter),
+		Jitter:     cfg.Jitter,
+		Once:       cfg.Once,
+		Polled:     readiness.polled,
+		Verbose:    cfg.Verbose,
+	}
+	for _, t := range cfg.Targets {
+		tlsConfig, _ := newTLSConfig(t.TLSCA, t.TLSInsecure) // validated in getConfig
+		if t.TLSInsecure {
+			logging.Errorf(logging.Fields{"target": reader.Redact(t.Target)}, "WARNING: TLS certificate verification is disabled for %q, the connection is not secure", reader.Redact(t.Target))
+		}
+		if t.Interval < Duration(recommendedReadInterval) && !cfg.Once {
+			logging.Errorf(logging.Fields{"target": reader.Redact(t.Target), "interval": time.Duration(t.Interval).String()}, "WARNING: %q is read every %s, intervals below %s risk overloading the node PC", reader.Redact(t.Target), time.Duration(t.Interval), recommendedReadInterval)
+		}
+		loc, _ := time.LoadLocation(t.Location) // validated in getConfig
+		pipeCfg.Targets = append(pipeCfg.Targets, &pipeline.Target{
+			Target:   t.Target,
+			Interval: time.Duration(t.Interval),
+			Options: reader.Options{
+				Timeout:    time.Duration(t.Timeout),
+				Username:   t.Username,
+				Password:   t.Password,
+				TimeFormat: t.TimeFormat,
+				TLSConfig:  tlsConfig,
+				Proxy:      proxyURL,
+				Header:     header,
+			},
+			Location: loc,
+		})
+	}
+	pipe, err := pipeline.New(pipeCfg)
+	if err != nil {
+		fail(exitConfig, "%v", err)
+	}
+	if err := pipe.Start(ctx); err != nil {
+		fail(exitFailure, "%v", err)
+	}
+	// Returns once all logs read have been processed after a shutdown, or after reading once.
+	if err := pipe.Wait(); err != nil {
+		os.Exit(exitFailure)
 	}
-	wg.Wait()
 }